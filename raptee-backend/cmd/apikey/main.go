@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/joho/godotenv"
+	"raptee-backend/middleware"
+	"raptee-backend/utils"
+)
+
+// Manages API keys for the dashboard and admin endpoints.
+//
+// Usage (from project root):
+//
+//	go run cmd/apikey/main.go create -name "flutter-dashboard" -role reader
+//	go run cmd/apikey/main.go list
+//	go run cmd/apikey/main.go revoke -id rk_3f9a1c2b
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	_ = godotenv.Overload()
+	dbUrl := os.Getenv("DATABASE_URL")
+	if dbUrl == "" {
+		log.Fatal("DATABASE_URL environment variable is not set.")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dbUrl)
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	switch os.Args[1] {
+	case "create":
+		fs := flag.NewFlagSet("create", flag.ExitOnError)
+		name := fs.String("name", "", "who/what the key is for (required)")
+		role := fs.String("role", middleware.RoleReader, "reader, operator or admin")
+		fs.Parse(os.Args[2:])
+		create(ctx, conn, *name, *role)
+	case "list":
+		list(ctx, conn)
+	case "revoke":
+		fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+		id := fs.String("id", "", "key_id to revoke (required)")
+		fs.Parse(os.Args[2:])
+		revoke(ctx, conn, *id)
+	default:
+		usage()
+	}
+}
+
+func create(ctx context.Context, conn *pgx.Conn, name, role string) {
+	if name == "" {
+		log.Fatal("-name is required")
+	}
+	if !middleware.IsValidRole(role) {
+		log.Fatalf("Invalid role %q (expected reader, operator or admin)", role)
+	}
+
+	keyID, key, err := utils.NewAPIKey()
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+
+	_, err = conn.Exec(ctx, "INSERT INTO api_keys (key_id, key_hash, name, role) VALUES ($1, $2, $3, $4)",
+		keyID, utils.HashAPIKey(key), name, role)
+	if err != nil {
+		log.Fatalf("Failed to store key: %v", err)
+	}
+
+	fmt.Printf("Created %s key %s for %q\n", role, keyID, name)
+	fmt.Printf("\n  %s\n\n", key)
+	fmt.Println("Store it now: only its hash is kept, it cannot be shown again.")
+}
+
+func list(ctx context.Context, conn *pgx.Conn) {
+	rows, err := conn.Query(ctx, "SELECT key_id, name, role, created_at, last_used_at, revoked_at FROM api_keys ORDER BY created_at")
+	if err != nil {
+		log.Fatalf("Failed to list keys: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Printf("%-12s %-24s %-9s %-20s %-20s %s\n", "KEY_ID", "NAME", "ROLE", "CREATED", "LAST_USED", "STATUS")
+	for rows.Next() {
+		var keyID, name, role string
+		var createdAt time.Time
+		var lastUsed, revokedAt *time.Time
+		if err := rows.Scan(&keyID, &name, &role, &createdAt, &lastUsed, &revokedAt); err != nil {
+			log.Fatalf("Failed to read key: %v", err)
+		}
+
+		status := "active"
+		if revokedAt != nil {
+			status = "revoked " + revokedAt.Format(time.DateOnly)
+		}
+		fmt.Printf("%-12s %-24s %-9s %-20s %-20s %s\n", keyID, name, role, createdAt.Format(time.DateTime), formatTime(lastUsed), status)
+	}
+}
+
+func revoke(ctx context.Context, conn *pgx.Conn, keyID string) {
+	if keyID == "" {
+		log.Fatal("-id is required")
+	}
+
+	res, err := conn.Exec(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE key_id = $1 AND revoked_at IS NULL", keyID)
+	if err != nil {
+		log.Fatalf("Failed to revoke key: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		log.Fatalf("No active key with id %s", keyID)
+	}
+	fmt.Printf("Revoked %s\n", keyID)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(time.DateTime)
+}
+
+func usage() {
+	fmt.Println("Usage: go run cmd/apikey/main.go <create|list|revoke> [flags]")
+	fmt.Println("  create -name <name> -role <reader|operator|admin>")
+	fmt.Println("  list")
+	fmt.Println("  revoke -id <key_id>")
+	os.Exit(1)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"raptee-backend/db"
+	"raptee-backend/handlers"
+	"raptee-backend/models"
+)
+
+// Benchmarks the sync write path against a local Postgres.
+// Compares the old one-Exec-per-row insert with the COPY + merge path used by
+// handlers.InsertTelemetryBatch.
+//
+// Usage (from project root, DATABASE_URL pointing at a migrated local DB):
+//
+//	go run cmd/bench-sync/main.go -rows 5000 -runs 3
+func main() {
+	numRows := flag.Int("rows", 5000, "rows per sync batch")
+	runs := flag.Int("runs", 3, "batches per strategy")
+	flag.Parse()
+
+	_ = godotenv.Overload()
+	db.Init()
+	defer db.Pool.Close()
+
+	ctx := context.Background()
+	bikeID := fmt.Sprintf("BENCH_BIKE_%s", uuid.New().String()[:8])
+	log.Printf("Benchmarking with bike %s: %d runs x %d rows", bikeID, *runs, *numRows)
+
+	// Clean up everything the benchmark wrote (cascades to telemetry_logs)
+	defer db.Pool.Exec(ctx, "DELETE FROM bikes WHERE bike_id = $1", bikeID)
+
+	var legacyTotal, copyTotal, resendTotal time.Duration
+	for i := 0; i < *runs; i++ {
+		// 1. Legacy: one round trip per row
+		req := generateBatch(bikeID, *numRows)
+		start := time.Now()
+		if err := insertRowByRow(ctx, req); err != nil {
+			log.Fatalf("Legacy insert failed: %v", err)
+		}
+		legacyTotal += time.Since(start)
+
+		// 2. Current: COPY into staging + single merge
+		req = generateBatch(bikeID, *numRows)
+		start = time.Now()
+		resp, err := handlers.InsertTelemetryBatch(ctx, req)
+		if err != nil {
+			log.Fatalf("Batch insert failed: %v", err)
+		}
+		copyTotal += time.Since(start)
+		if resp.Accepted != *numRows {
+			log.Fatalf("Expected %d accepted rows, got %+v", *numRows, resp)
+		}
+
+		// 3. Resend the same batch: every row must come back as a duplicate
+		start = time.Now()
+		resp, err = handlers.InsertTelemetryBatch(ctx, req)
+		if err != nil {
+			log.Fatalf("Resend failed: %v", err)
+		}
+		resendTotal += time.Since(start)
+		if resp.Accepted != 0 || resp.Duplicates != *numRows {
+			log.Fatalf("Resend was not idempotent: %+v", resp)
+		}
+	}
+
+	total := float64(*runs * *numRows)
+	fmt.Println()
+	fmt.Printf("%-22s %12s %14s\n", "Strategy", "Total", "Rows/sec")
+	report := func(name string, d time.Duration) {
+		fmt.Printf("%-22s %12s %14.0f\n", name, d.Round(time.Millisecond), total/d.Seconds())
+	}
+	report("row-by-row Exec", legacyTotal)
+	report("COPY + merge", copyTotal)
+	report("COPY + merge (resend)", resendTotal)
+	fmt.Printf("\nSpeedup: %.1fx\n", legacyTotal.Seconds()/copyTotal.Seconds())
+}
+
+// insertRowByRow is the original sync write path, kept here as the baseline.
+func insertRowByRow(ctx context.Context, req models.CompactRequest) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO bikes (bike_id, last_seen_at) VALUES ($1, NOW()) ON CONFLICT (bike_id) DO UPDATE SET last_seen_at = NOW()`, req.BikeID)
+	if err != nil {
+		return err
+	}
+
+	sql := `
+	INSERT INTO telemetry_logs (
+		log_id, bike_id, logged_at, log_type, val_primary, location, payload
+	) VALUES (
+		$1, $2, $3, $4, $5, ST_SetSRID(ST_MakePoint($6, $7), 4326), $8
+	) ON CONFLICT (bike_id, logged_at, log_id) DO NOTHING`
+
+	for _, row := range req.Data {
+		if _, err := tx.Exec(ctx, sql, row[0], req.BikeID, row[1], row[2], int(row[3].(float64)), row[5], row[4], row[6]); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// generateBatch builds an offline backlog shaped like real firmware output
+func generateBatch(bikeID string, n int) models.CompactRequest {
+	now := time.Now().UTC()
+	data := make([][]interface{}, n)
+	for i := range data {
+		data[i] = []interface{}{
+			uuid.New().String(),
+			now.Add(time.Duration(-i) * time.Second).Format(time.RFC3339),
+			"API_LATENCY",
+			float64(100 + i%5000),
+			13.0827,
+			80.2707,
+			[]interface{}{"charging_station", "success", float64(200), "", float64(4), "connected", "4G"},
+		}
+	}
+
+	return models.CompactRequest{
+		BikeID:    bikeID,
+		Timestamp: now.Format(time.RFC3339),
+		Columns:   []string{"uuid", "timestamp", "type", "val_primary", "lat", "lng", "payload"},
+		Data:      data,
+	}
+}
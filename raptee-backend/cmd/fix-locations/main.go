@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/joho/godotenv"
+	"raptee-backend/db"
+)
+
+// Repairs rows synced before missing coordinates were stored as NULL: the
+// sync handler used to write them at (0,0) ("Null Island"), where they
+// polluted every geo query. Rows are updated in batches, each in its own
+// transaction, so the table is never locked for long and an interrupted run
+// can simply be restarted.
+//
+// Trips and geofence events never used (0,0) rows, so nothing needs
+// rebuilding afterwards.
+//
+// Usage (from project root):
+//
+//	go run cmd/fix-locations/main.go
+//	go run cmd/fix-locations/main.go -batch 10000 -pause 200ms
+func main() {
+	batch := flag.Int("batch", 5000, "rows updated per transaction")
+	pause := flag.Duration("pause", 0, "sleep between batches, to leave room for live traffic")
+	flag.Parse()
+
+	_ = godotenv.Overload()
+	db.Init()
+	defer db.Pool.Close()
+
+	ctx := context.Background()
+	total := 0
+	for {
+		// The && bounding-box test lets idx_telemetry_geom find the rows.
+		// Rows are matched by physical address (partition + ctid), so old rows
+		// without a log_id are repaired too.
+		res, err := db.Pool.Exec(ctx, `
+		WITH batch AS (
+			SELECT tableoid, ctid FROM telemetry_logs
+			WHERE location::geometry && ST_MakeEnvelope(0, 0, 0, 0, 4326)
+				AND ST_X(location::geometry) = 0 AND ST_Y(location::geometry) = 0
+			LIMIT $1
+		)
+		UPDATE telemetry_logs t SET location = NULL, gps_flag = NULL
+		FROM batch WHERE t.tableoid = batch.tableoid AND t.ctid = batch.ctid`, *batch)
+		if err != nil {
+			log.Fatalf("Failed after %d rows: %v", total, err)
+		}
+		n := int(res.RowsAffected())
+		if n == 0 {
+			break
+		}
+		total += n
+		log.Printf("Cleared %d rows (%d so far)", n, total)
+		time.Sleep(*pause)
+	}
+	log.Printf("Done: %d rows no longer at (0,0)", total)
+}
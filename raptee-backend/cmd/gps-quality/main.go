@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/joho/godotenv"
+	"raptee-backend/db"
+	"raptee-backend/handlers"
+)
+
+// Re-runs the GPS quality check over raw telemetry_logs, rewriting gps_flag
+// and the server-synthesized GPS_ANOMALY rows. The sync handler checks rows on
+// ingest; run this once after migrating (to cover telemetry stored before the
+// check existed) or after changing GPS_REGION_BBOX, then rebuild trips
+// (cmd/trips) so they follow the new flags.
+//
+// Usage (from project root):
+//
+//	go run cmd/gps-quality/main.go              # every registered bike
+//	go run cmd/gps-quality/main.go -bike BIKE_001
+func main() {
+	bikeID := flag.String("bike", "", "check a single bike (default: all bikes)")
+	flag.Parse()
+
+	_ = godotenv.Overload()
+	if err := handlers.LoadGPSRegion(); err != nil {
+		log.Fatalf("Invalid GPS region: %v", err)
+	}
+	db.Init()
+	defer db.Pool.Close()
+
+	ctx := context.Background()
+	bikes := []string{*bikeID}
+	if *bikeID == "" {
+		var err error
+		if bikes, err = allBikes(ctx); err != nil {
+			log.Fatalf("Failed to list bikes: %v", err)
+		}
+	}
+
+	total := 0
+	for _, id := range bikes {
+		n, err := handlers.RebuildGPSFlags(ctx, id)
+		if err != nil {
+			log.Fatalf("Failed to check GPS for %s: %v", id, err)
+		}
+		log.Printf("%s: %d flagged fixes", id, n)
+		total += n
+	}
+	log.Printf("Done: %d bikes, %d flagged fixes", len(bikes), total)
+}
+
+func allBikes(ctx context.Context) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT bike_id FROM bikes ORDER BY bike_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/joho/godotenv"
+	"raptee-backend/db"
+)
+
+// Runs one round of telemetry_logs partition maintenance with the server's
+// settings (PARTITION_MONTHS_AHEAD, PARTITION_RETENTION_MONTHS), or lists the
+// partitions. The server already does this every hour; use it to create
+// partitions further ahead before a large backfill, or to check what exists.
+//
+// After migrating to schema/016, run it once with -backfill to move the
+// existing telemetry into the partitioned table (in small batches, with the
+// server running).
+//
+// Usage (from project root):
+//
+//	go run cmd/partitions/main.go              # create/drop now
+//	go run cmd/partitions/main.go -ahead 12    # create the next 12 months
+//	go run cmd/partitions/main.go -backfill
+//	go run cmd/partitions/main.go -list
+func main() {
+	ahead := flag.Int("ahead", -1, "months to create ahead (default: PARTITION_MONTHS_AHEAD)")
+	list := flag.Bool("list", false, "list partitions and their row estimates instead")
+	backfill := flag.Bool("backfill", false, "move rows left in telemetry_logs_unpartitioned by schema/016 instead")
+	flag.Parse()
+
+	_ = godotenv.Overload()
+	cfg, err := db.LoadPartitionConfig()
+	if err != nil {
+		log.Fatalf("Invalid partition config: %v", err)
+	}
+	if *ahead >= 0 {
+		cfg.MonthsAhead = *ahead
+	}
+	db.Init()
+	defer db.Pool.Close()
+
+	ctx := context.Background()
+	if *list {
+		listPartitions(ctx)
+		return
+	}
+	if *backfill {
+		moved, discarded, err := db.BackfillPartitions(ctx)
+		if err != nil {
+			log.Fatalf("Backfill failed after moving %d rows: %v", moved, err)
+		}
+		log.Printf("Moved %d rows, discarded %d duplicate log_ids", moved, discarded)
+		return
+	}
+
+	report, err := db.MaintainPartitions(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Partition maintenance failed: %v", err)
+	}
+	if report.Skipped {
+		log.Fatalf("Another instance is maintaining partitions; try again shortly")
+	}
+	log.Printf("Created %d partitions %v", len(report.Created), report.Created)
+	if cutoff := cfg.Cutoff(time.Now()); !cutoff.IsZero() {
+		log.Printf("Dropped %d partitions before %s %v", len(report.Dropped), cutoff.Format("2006-01"), report.Dropped)
+	}
+}
+
+func listPartitions(ctx context.Context) {
+	rows, err := db.Pool.Query(ctx, `
+	SELECT c.relname, pg_get_expr(c.relpartbound, c.oid), GREATEST(c.reltuples, 0)::bigint
+	FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = 'telemetry_logs'::regclass
+	ORDER BY c.relname`)
+	if err != nil {
+		log.Fatalf("Failed to list partitions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, bound string
+		var estimate int64
+		if err := rows.Scan(&name, &bound, &estimate); err != nil {
+			log.Fatalf("Failed to list partitions: %v", err)
+		}
+		log.Printf("%-26s ~%d rows  %s", name, estimate, bound)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to list partitions: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/joho/godotenv"
+	"raptee-backend/db"
+	"raptee-backend/handlers"
+)
+
+// Rebuilds telemetry_rollups from raw telemetry_logs. The sync handler keeps
+// rollups current on ingest; run this once after migrating (to cover telemetry
+// stored before rollups existed) or to repair a bike's rollups.
+//
+// Usage (from project root):
+//
+//	go run cmd/rollups/main.go              # every registered bike
+//	go run cmd/rollups/main.go -bike BIKE_001
+func main() {
+	bikeID := flag.String("bike", "", "rebuild a single bike (default: all bikes)")
+	flag.Parse()
+
+	_ = godotenv.Overload()
+	db.Init()
+	defer db.Pool.Close()
+
+	ctx := context.Background()
+	bikes := []string{*bikeID}
+	if *bikeID == "" {
+		var err error
+		if bikes, err = allBikes(ctx); err != nil {
+			log.Fatalf("Failed to list bikes: %v", err)
+		}
+	}
+
+	total := 0
+	for _, id := range bikes {
+		n, err := handlers.RebuildRollups(ctx, id)
+		if err != nil {
+			log.Fatalf("Failed to rebuild rollups for %s: %v", id, err)
+		}
+		log.Printf("%s: %d API_LATENCY rows rolled up", id, n)
+		total += n
+	}
+	log.Printf("Done: %d bikes, %d rows", len(bikes), total)
+}
+
+func allBikes(ctx context.Context) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT bike_id FROM bikes ORDER BY bike_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -5,12 +5,34 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"raptee-backend/models"
 )
 
 var Pool *pgxpool.Pool
-var GlobalSchemas map[string][]string
+
+// SchemaChannel is the NOTIFY channel fired by the log_schemas trigger
+const SchemaChannel = "log_schemas_changed"
+
+// SchemaKey identifies one version of a log type's payload layout
+type SchemaKey struct {
+	LogType string
+	Version int
+}
+
+// DefaultSchemaVersion is assumed when a bike doesn't declare one and its
+// payload doesn't identify another (see SchemaVersionForFields)
+const DefaultSchemaVersion = 1
+
+// globalSchemas maps (log_type, version) -> payload field definitions. It is swapped
+// as a whole on reload, so readers only need the read lock to grab the current map.
+var (
+	schemaMu      sync.RWMutex
+	globalSchemas = map[SchemaKey][]models.FieldDef{}
+)
 
 // Init initializes the database connection and loads schemas
 func Init() {
@@ -29,19 +51,133 @@ func Init() {
 	// The main function can handle closing if needed, or we just let it die with the process.
 
 	// 2. Load Global Schemas
-	GlobalSchemas = make(map[string][]string)
-	rows, err := Pool.Query(context.Background(), "SELECT log_type, fields FROM log_schemas")
-	if err != nil {
+	if err := LoadSchemas(context.Background()); err != nil {
 		log.Printf("Warning: Could not load schemas: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var lType string
-			var fields []string
-			if err := rows.Scan(&lType, &fields); err == nil {
-				GlobalSchemas[lType] = fields
-			}
+	}
+	fmt.Printf("Loaded %d schemas\n", len(ListSchemas()))
+}
+
+// GetSchema returns the field definitions registered for a log type version
+func GetSchema(logType string, version int) ([]models.FieldDef, bool) {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	fields, ok := globalSchemas[SchemaKey{logType, version}]
+	return fields, ok
+}
+
+// SchemaVersionForFields returns the lowest registered version of a log type
+// whose payload has n fields, for rows that don't declare a version
+func SchemaVersionForFields(logType string, n int) (int, bool) {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	version := 0
+	for k, fields := range globalSchemas {
+		if k.LogType == logType && len(fields) == n && (version == 0 || k.Version < version) {
+			version = k.Version
+		}
+	}
+	return version, version != 0
+}
+
+// HasLogType reports whether any version of a log type is registered
+func HasLogType(logType string) bool {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	for k := range globalSchemas {
+		if k.LogType == logType {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSchemas returns a snapshot of all registered schemas
+func ListSchemas() map[SchemaKey][]models.FieldDef {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	out := make(map[SchemaKey][]models.FieldDef, len(globalSchemas))
+	for k, v := range globalSchemas {
+		out[k] = v
+	}
+	return out
+}
+
+// LoadSchemas (re)reads log_schemas and atomically replaces the in-memory copy
+func LoadSchemas(ctx context.Context) error {
+	rows, err := Pool.Query(ctx, "SELECT log_type, version, fields, field_defs FROM log_schemas")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	schemas := make(map[SchemaKey][]models.FieldDef)
+	for rows.Next() {
+		var key SchemaKey
+		var names []string
+		var defs []models.FieldDef
+		if err := rows.Scan(&key.LogType, &key.Version, &names, &defs); err != nil {
+			return err
+		}
+		if len(defs) == 0 {
+			defs = models.UntypedFields(names)
+		}
+		schemas[key] = defs
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	SetSchemas(schemas)
+	return nil
+}
+
+// SetSchemas atomically replaces the in-memory schemas (LoadSchemas, tests)
+func SetSchemas(schemas map[SchemaKey][]models.FieldDef) {
+	schemaMu.Lock()
+	globalSchemas = schemas
+	schemaMu.Unlock()
+}
+
+// ListenForSchemaChanges keeps the in-memory schemas in sync with log_schemas
+// across all instances, using Postgres LISTEN/NOTIFY. It blocks until ctx is
+// cancelled, reconnecting (and reloading, in case notifications were missed)
+// whenever the listening connection drops.
+func ListenForSchemaChanges(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := listenSchemas(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Schema listener error: %v (retrying in 5s)", err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+func listenSchemas(ctx context.Context) error {
+	conn, err := Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Don't hand a listening connection back to the pool
+		conn.Exec(context.Background(), "UNLISTEN *")
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+SchemaChannel); err != nil {
+		return err
+	}
+	// Catch up on anything that changed while we weren't listening
+	if err := LoadSchemas(ctx); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if err := LoadSchemas(ctx); err != nil {
+			return err
 		}
+		log.Printf("Reloaded schemas (%s changed)", n.Payload)
 	}
-	fmt.Printf("Loaded %d schemas\n", len(GlobalSchemas))
 }
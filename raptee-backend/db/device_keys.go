@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/utils"
+)
+
+// SealLegacyDeviceKeys moves device HMAC keys still stored in plaintext
+// (bikes.device_key_hash, schema 004) into the sealed device_key_enc column
+// and clears them. It returns how many bikes were converted; once every key
+// is sealed it is a no-op. Call after utils.InitDeviceKeyCipher.
+func SealLegacyDeviceKeys(ctx context.Context) (int, error) {
+	rows, err := Pool.Query(ctx, "SELECT bike_id, device_key_hash FROM bikes WHERE device_key_hash IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	type legacyKey struct{ bikeID, key string }
+	legacy, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (legacyKey, error) {
+		var k legacyKey
+		err := row.Scan(&k.bikeID, &k.key)
+		return k, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sealed := 0
+	for _, k := range legacy {
+		enc, err := utils.SealDeviceKey(k.bikeID, k.key)
+		if err != nil {
+			return sealed, err
+		}
+		// Skip a bike whose key changed since it was read (another instance won)
+		res, err := Pool.Exec(ctx, `
+			UPDATE bikes SET device_key_enc = $2, device_key_hash = NULL
+			WHERE bike_id = $1 AND device_key_hash = $3`, k.bikeID, enc, k.key)
+		if err != nil {
+			return sealed, err
+		}
+		sealed += int(res.RowsAffected())
+	}
+	return sealed, nil
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// telemetry_logs is range-partitioned by UTC month (schema/016). The server
+// keeps partitions created ahead of time and, when a retention is set, drops
+// the months that have expired along with their trips and geofence events.
+
+// PartitionInterval is how often the server runs MaintainPartitions
+const PartitionInterval = time.Hour
+
+// PartitionConfig controls partition maintenance
+type PartitionConfig struct {
+	MonthsAhead     int // Future months to keep created (besides the current one)
+	RetentionMonths int // Full months of telemetry to keep before the current one; 0 keeps everything
+}
+
+// LoadPartitionConfig reads PARTITION_MONTHS_AHEAD (default 3) and
+// PARTITION_RETENTION_MONTHS (default 0, never drop)
+func LoadPartitionConfig() (PartitionConfig, error) {
+	cfg := PartitionConfig{MonthsAhead: 3}
+	for _, v := range []struct {
+		env string
+		dst *int
+	}{
+		{"PARTITION_MONTHS_AHEAD", &cfg.MonthsAhead},
+		{"PARTITION_RETENTION_MONTHS", &cfg.RetentionMonths},
+	} {
+		s := os.Getenv(v.env)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("%s must be a non-negative integer", v.env)
+		}
+		*v.dst = n
+	}
+	return cfg, nil
+}
+
+// Cutoff is the start of the oldest month kept, or zero if nothing expires
+func (cfg PartitionConfig) Cutoff(now time.Time) time.Time {
+	if cfg.RetentionMonths == 0 {
+		return time.Time{}
+	}
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()-time.Month(cfg.RetentionMonths), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// PartitionReport is what one MaintainPartitions run changed
+type PartitionReport struct {
+	Skipped bool     // Another instance holds the maintenance lock
+	Created []string // New partitions
+	Dropped []string // Expired partitions
+}
+
+// MaintainPartitions creates the partitions of the current month and the
+// next cfg.MonthsAhead, and drops the ones older than the retention. Only
+// one instance runs it at a time; the others skip.
+func MaintainPartitions(ctx context.Context, cfg PartitionConfig) (PartitionReport, error) {
+	var report PartitionReport
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock(hashtext('partitions'))").Scan(&locked); err != nil {
+		return report, err
+	}
+	if !locked {
+		report.Skipped = true
+		return report, nil
+	}
+	// Attaching and detaching lock telemetry_logs; give up rather than queue
+	// behind a long read and stall syncs behind us
+	if _, err := tx.Exec(ctx, "SET LOCAL lock_timeout = '5s'"); err != nil {
+		return report, err
+	}
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= cfg.MonthsAhead; i++ {
+		var name *string
+		if err := tx.QueryRow(ctx, "SELECT create_telemetry_partition($1)", month.AddDate(0, i, 0)).Scan(&name); err != nil {
+			return report, err
+		}
+		if name != nil {
+			report.Created = append(report.Created, *name)
+		}
+	}
+
+	if cutoff := cfg.Cutoff(now); !cutoff.IsZero() {
+		if report.Dropped, err = dropExpired(ctx, tx, cutoff); err != nil {
+			return report, err
+		}
+	}
+	return report, tx.Commit(ctx)
+}
+
+// dropExpired drops the telemetry before cutoff and what was derived from it.
+// Rollups are aggregates and are kept; telemetry_pruned records the cutoff that
+// raw analytics reads start at.
+func dropExpired(ctx context.Context, tx pgx.Tx, cutoff time.Time) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT drop_telemetry_partitions($1)", cutoff)
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(ctx, "DELETE FROM telemetry_logs_default WHERE logged_at < $1", cutoff)
+	if err != nil {
+		return nil, err
+	}
+	if len(dropped) > 0 || res.RowsAffected() > 0 {
+		if err := markPruned(ctx, tx, "*", cutoff); err != nil {
+			return nil, err
+		}
+	}
+	for _, sql := range []string{
+		"DELETE FROM trips WHERE ended_at < $1",
+		"DELETE FROM geofence_events WHERE occurred_at < $1",
+	} {
+		if _, err := tx.Exec(ctx, sql, cutoff); err != nil {
+			return nil, err
+		}
+	}
+	return dropped, nil
+}
+
+// BackfillBatchSize is the most rows one BackfillPartitions statement moves
+const BackfillBatchSize = 5000
+
+// BackfillPartitions moves the rows schema/016 left in
+// telemetry_logs_unpartitioned into telemetry_logs, bike by bike and oldest
+// first, one batch per transaction, then drops the old table. Rows whose
+// log_id the bike already has in telemetry_logs (e.g. resent since the
+// migration) are discarded. It returns the rows moved and discarded, and is
+// safe to re-run after an interruption.
+func BackfillPartitions(ctx context.Context) (moved, discarded int64, err error) {
+	var exists bool
+	if err := Pool.QueryRow(ctx, "SELECT to_regclass('telemetry_logs_unpartitioned') IS NOT NULL").Scan(&exists); err != nil || !exists {
+		return 0, 0, err
+	}
+
+	rows, err := Pool.Query(ctx, "SELECT bike_id FROM bikes ORDER BY bike_id")
+	if err != nil {
+		return 0, 0, err
+	}
+	bikes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, bikeID := range bikes {
+		for {
+			m, n, err := backfillBatch(ctx, bikeID)
+			moved, discarded = moved+n, discarded+m-n
+			if err != nil {
+				return moved, discarded, err
+			}
+			if m < BackfillBatchSize {
+				break
+			}
+		}
+	}
+
+	// Every row belongs to a bike (FK), so the table is empty now unless a
+	// bike was registered mid-run; re-running picks those up
+	var left bool
+	if err := Pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM telemetry_logs_unpartitioned)").Scan(&left); err != nil || left {
+		return moved, discarded, err
+	}
+	_, err = Pool.Exec(ctx, "DROP TABLE telemetry_logs_unpartitioned")
+	return moved, discarded, err
+}
+
+// backfillBatch moves one batch of a bike's old rows. It locks the bike's row
+// like a sync does, so the log_id check can't race a concurrent sync. It
+// returns the rows taken from the old table and those inserted.
+func backfillBatch(ctx context.Context, bikeID string) (taken, inserted int64, err error) {
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM bikes WHERE bike_id = $1 FOR UPDATE", bikeID); err != nil {
+		return 0, 0, err
+	}
+	err = tx.QueryRow(ctx, `
+	WITH moved AS (
+		DELETE FROM telemetry_logs_unpartitioned
+		WHERE ctid = ANY(ARRAY(
+			SELECT ctid FROM telemetry_logs_unpartitioned
+			WHERE bike_id = $1 ORDER BY logged_at LIMIT $2
+		))
+		RETURNING *
+	), fresh AS (
+		SELECT DISTINCT ON (log_id) COALESCE(log_id, gen_random_uuid()) AS log_id, bike_id, logged_at, log_type,
+			val_primary, location, payload, schema_version,
+			gps_flag, val_secondary, speed, altitude, heading, accuracy, battery_pct
+		FROM moved m
+		WHERE m.log_id IS NULL
+			OR NOT EXISTS (SELECT 1 FROM telemetry_logs t WHERE t.bike_id = $1 AND t.log_id = m.log_id)
+		ORDER BY log_id, logged_at
+	), ins AS (
+		INSERT INTO telemetry_logs (
+			log_id, bike_id, logged_at, log_type, val_primary, location, payload, schema_version,
+			gps_flag, val_secondary, speed, altitude, heading, accuracy, battery_pct
+		)
+		SELECT * FROM fresh
+		ON CONFLICT (bike_id, logged_at, log_id) DO NOTHING
+		RETURNING 1
+	)
+	SELECT (SELECT count(*) FROM moved), (SELECT count(*) FROM ins)`, bikeID, BackfillBatchSize).Scan(&taken, &inserted)
+	if err != nil {
+		return 0, 0, err
+	}
+	return taken, inserted, tx.Commit(ctx)
+}
+
+// RunPartitionMaintenance runs MaintainPartitions now and every
+// PartitionInterval until ctx is cancelled
+func RunPartitionMaintenance(ctx context.Context, cfg PartitionConfig) {
+	var pending bool
+	if err := Pool.QueryRow(ctx, "SELECT to_regclass('telemetry_logs_unpartitioned') IS NOT NULL").Scan(&pending); err == nil && pending {
+		log.Println("WARNING: telemetry_logs_unpartitioned still holds pre-partitioning telemetry; run cmd/partitions -backfill")
+	}
+
+	ticker := time.NewTicker(PartitionInterval)
+	defer ticker.Stop()
+	for {
+		report, err := MaintainPartitions(ctx, cfg)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("Partition maintenance error: %v", err)
+		case len(report.Created) > 0 || len(report.Dropped) > 0:
+			log.Printf("Partitions: created %v, dropped %v", report.Created, report.Dropped)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Rows of a log type older than its retention_policies.retain_days are pruned
+// bike by bike, a bounded batch per statement, so a large first pass never
+// holds locks or bloats WAL the way one big DELETE would. Rollups, trips and
+// geofence events are kept; they go with whole partitions (see partitions.go).
+// How far back rows were deleted is recorded in telemetry_pruned (see
+// RawCutoff), so raw analytics reads can say where they start.
+
+const (
+	// RetentionInterval is how often the server runs PruneExpired
+	RetentionInterval = time.Hour
+	// RetentionBatchSize is the most rows one DELETE removes
+	RetentionBatchSize = 5000
+	// retentionPause lets other writers and vacuum in between batches
+	retentionPause = 100 * time.Millisecond
+)
+
+// RetentionReport is what one PruneExpired run deleted, by log type
+type RetentionReport struct {
+	Skipped bool             // Another instance is pruning
+	Pruned  map[string]int64 // Rows deleted per log type with a policy
+}
+
+// PruneExpired applies every retention policy once and updates its counters.
+// Only one instance prunes at a time; the others skip.
+func PruneExpired(ctx context.Context) (RetentionReport, error) {
+	report := RetentionReport{Pruned: map[string]int64{}}
+	conn, err := Pool.Acquire(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext('retention'))").Scan(&locked); err != nil {
+		return report, err
+	}
+	if !locked {
+		report.Skipped = true
+		return report, nil
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext('retention'))")
+
+	type policy struct {
+		logType string
+		days    int
+	}
+	rows, err := conn.Query(ctx, "SELECT log_type, retain_days FROM retention_policies ORDER BY log_type")
+	if err != nil {
+		return report, err
+	}
+	policies, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (policy, error) {
+		var p policy
+		return p, row.Scan(&p.logType, &p.days)
+	})
+	if err != nil || len(policies) == 0 {
+		return report, err
+	}
+
+	rows, err = conn.Query(ctx, "SELECT bike_id FROM bikes ORDER BY bike_id")
+	if err != nil {
+		return report, err
+	}
+	bikes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return report, err
+	}
+
+	for _, p := range policies {
+		cutoff := time.Now().Add(-time.Duration(p.days) * 24 * time.Hour)
+		var pruned int64
+		for _, bikeID := range bikes {
+			n, err := pruneBike(ctx, conn, bikeID, p.logType, cutoff)
+			pruned += n
+			if err != nil {
+				recordPruned(conn, p.logType, cutoff, pruned)
+				report.Pruned[p.logType] = pruned
+				return report, err
+			}
+		}
+		if err := recordPruned(conn, p.logType, cutoff, pruned); err != nil {
+			return report, err
+		}
+		report.Pruned[p.logType] = pruned
+	}
+	return report, nil
+}
+
+// pruneBike deletes a bike's rows of logType before cutoff, oldest first, in
+// batches of RetentionBatchSize (each its own transaction)
+func pruneBike(ctx context.Context, conn *pgxpool.Conn, bikeID, logType string, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		res, err := conn.Exec(ctx, `
+		DELETE FROM telemetry_logs t USING (
+			SELECT logged_at, log_id FROM telemetry_logs
+			WHERE bike_id = $1 AND log_type = $2 AND logged_at < $3
+			ORDER BY logged_at LIMIT $4
+		) d
+		WHERE t.bike_id = $1 AND t.log_type = $2 AND t.logged_at = d.logged_at
+			AND t.log_id = d.log_id`, bikeID, logType, cutoff, RetentionBatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += res.RowsAffected()
+		if res.RowsAffected() < RetentionBatchSize {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(retentionPause):
+		}
+	}
+}
+
+// recordPruned stores a policy's last run and adds to its running total, and
+// advances the log type's telemetry_pruned mark if anything was deleted. It
+// uses its own context so a cancelled run still records what it deleted.
+func recordPruned(conn *pgxpool.Conn, logType string, cutoff time.Time, pruned int64) error {
+	_, err := conn.Exec(context.Background(), `
+	UPDATE retention_policies
+	SET last_run_at = NOW(), last_run_pruned = $2, total_pruned = total_pruned + $2
+	WHERE log_type = $1`, logType, pruned)
+	if err != nil || pruned == 0 {
+		return err
+	}
+	return markPruned(context.Background(), conn, logType, cutoff)
+}
+
+// execer is a connection or transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// markPruned records that logType's raw rows before cutoff may be gone
+func markPruned(ctx context.Context, q execer, logType string, cutoff time.Time) error {
+	_, err := q.Exec(ctx, `
+	INSERT INTO telemetry_pruned (log_type, pruned_before) VALUES ($1, $2)
+	ON CONFLICT (log_type) DO UPDATE SET pruned_before = GREATEST(telemetry_pruned.pruned_before, EXCLUDED.pruned_before)`,
+		logType, cutoff)
+	return err
+}
+
+// RawCutoff returns the time before which raw rows of logType may have been
+// pruned, by its retention policy or with expired partitions, or zero if none
+// ever were
+func RawCutoff(ctx context.Context, logType string) (time.Time, error) {
+	var cutoff *time.Time
+	err := Pool.QueryRow(ctx, "SELECT max(pruned_before) FROM telemetry_pruned WHERE log_type IN ($1, '*')", logType).Scan(&cutoff)
+	if err != nil || cutoff == nil {
+		return time.Time{}, err
+	}
+	return *cutoff, nil
+}
+
+// retentionNow asks RunRetention for a pass before the next tick
+var retentionNow = make(chan struct{}, 1)
+
+// TriggerRetention asks the RunRetention worker for a pass now and returns
+// without waiting for it. A pass requested while one is pending is not queued
+// twice.
+func TriggerRetention() {
+	select {
+	case retentionNow <- struct{}{}:
+	default:
+	}
+}
+
+// RunRetention runs PruneExpired now, every RetentionInterval and on
+// TriggerRetention until ctx is cancelled
+func RunRetention(ctx context.Context) {
+	ticker := time.NewTicker(RetentionInterval)
+	defer ticker.Stop()
+	for {
+		report, err := PruneExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Retention error: %v", err)
+		}
+		if report.Skipped {
+			log.Println("Retention: skipped, another instance is pruning")
+		}
+		for logType, n := range report.Pruned {
+			if n > 0 {
+				log.Printf("Retention: pruned %d %s rows", n, logType)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-retentionNow:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package grpcserver
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// rawCodec is the standard proto codec, except that it remembers the exact
+// bytes each request was decoded from. Device signatures are checked over
+// those bytes, as sent by the bike: protobuf encodings aren't canonical across
+// implementations (e.g. nanopb vs Go), so a server-side re-encoding could
+// differ from what was signed.
+type rawCodec struct{}
+
+// rawBodies maps a decoded request message to its wire bytes until
+// deviceAuthInterceptor takes them
+var rawBodies sync.Map
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return err
+	}
+	// gRPC may reuse data once we return
+	rawBodies.Store(msg, bytes.Clone(data))
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// takeRawBody returns (and forgets) the wire bytes req was decoded from
+func takeRawBody(req interface{}) ([]byte, bool) {
+	body, ok := rawBodies.LoadAndDelete(req)
+	if !ok {
+		return nil, false
+	}
+	return body.([]byte), true
+}
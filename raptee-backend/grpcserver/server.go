@@ -0,0 +1,139 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"raptee-backend/handlers"
+	"raptee-backend/middleware"
+	"raptee-backend/models"
+	telemetrypb "raptee-backend/proto"
+)
+
+// syncColumns is the compact column layout a typed TelemetryRow maps onto
+var syncColumns = []string{"uuid", "timestamp", "type", "val_primary", "lat", "lng", "payload", "schema_version",
+	"val_secondary", "speed", "altitude", "heading", "accuracy", "battery_pct"}
+
+// TelemetryServer implements telemetrypb.TelemetryServiceServer
+type TelemetryServer struct {
+	telemetrypb.UnimplementedTelemetryServiceServer
+}
+
+// New creates a gRPC server with the telemetry service registered
+func New() *grpc.Server {
+	s := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnaryInterceptor(deviceAuthInterceptor))
+	telemetrypb.RegisterTelemetryServiceServer(s, &TelemetryServer{})
+	return s
+}
+
+// Sync converts the typed batch into a CompactRequest and hands it to the same
+// validation + insert path as POST /api/v1/sync.
+func (s *TelemetryServer) Sync(ctx context.Context, req *telemetrypb.SyncRequest) (*telemetrypb.SyncResponse, error) {
+	if req.GetBikeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "bike_id is required")
+	}
+	if req.GetBikeId() != ctx.Value(bikeIDKey{}) {
+		return nil, status.Error(codes.PermissionDenied, "bike_id does not match the signing bike")
+	}
+
+	resp, err := handlers.InsertTelemetryBatch(ctx, toCompactRequest(req))
+	if err != nil {
+		log.Printf("gRPC sync error: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	out := &telemetrypb.SyncResponse{
+		Status:     resp.Status,
+		Accepted:   int32(resp.Accepted),
+		Duplicates: int32(resp.Duplicates),
+	}
+	for _, r := range resp.Rejected {
+		out.Rejected = append(out.Rejected, &telemetrypb.RowRejection{Index: int32(r.Index), Reason: r.Reason})
+	}
+	return out, nil
+}
+
+// bikeIDKey is the context key holding the authenticated bike_id
+type bikeIDKey struct{}
+
+// deviceAuthInterceptor is the gRPC counterpart of middleware.DeviceAuth.
+// The x-bike-id, x-timestamp, x-nonce and x-signature metadata carry the same
+// values as the HTTP headers. The signature covers method POST, the full
+// method name as path (e.g. /raptee.telemetry.v1.TelemetryService/Sync) and
+// the request message's bytes exactly as sent (see rawCodec).
+func deviceAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	body, ok := takeRawBody(req)
+	if !ok {
+		return nil, status.Error(codes.Internal, "request bytes unavailable")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	bikeID := first("x-bike-id")
+	err := middleware.VerifyDeviceSignature(ctx, middleware.SignedRequest{
+		BikeID:    bikeID,
+		Method:    "POST",
+		Path:      info.FullMethod,
+		Timestamp: first("x-timestamp"),
+		Nonce:     first("x-nonce"),
+		Signature: first("x-signature"),
+		Body:      body,
+	})
+	if err != nil {
+		if middleware.IsAuthError(err) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return handler(context.WithValue(ctx, bikeIDKey{}, bikeID), req)
+}
+
+// toCompactRequest builds rows with the same value shapes encoding/json
+// produces (float64 numbers, nil for absent fields).
+func toCompactRequest(req *telemetrypb.SyncRequest) models.CompactRequest {
+	data := make([][]interface{}, 0, len(req.GetRows()))
+	for _, r := range req.GetRows() {
+		row := make([]interface{}, len(syncColumns))
+		row[0], row[1], row[2] = r.GetUuid(), r.GetTimestamp(), r.GetLogType()
+		if r.ValPrimary != nil {
+			row[3] = float64(r.GetValPrimary())
+		}
+		if r.Lat != nil {
+			row[4] = r.GetLat()
+		}
+		if r.Lng != nil {
+			row[5] = r.GetLng()
+		}
+		if r.Payload != nil {
+			row[6] = r.GetPayload().AsInterface()
+		}
+		if r.SchemaVersion != nil {
+			row[7] = float64(r.GetSchemaVersion())
+		}
+		for i, v := range []*float64{r.ValSecondary, r.Speed, r.Altitude, r.Heading, r.Accuracy, r.BatteryPct} {
+			if v != nil {
+				row[8+i] = *v
+			}
+		}
+		data = append(data, row)
+	}
+
+	return models.CompactRequest{
+		BikeID:        req.GetBikeId(),
+		Timestamp:     req.GetSyncTimestamp(),
+		SchemaVersion: int(req.GetSchemaVersion()),
+		Columns:       syncColumns,
+		Data:          data,
+	}
+}
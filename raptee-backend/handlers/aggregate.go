@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"raptee-backend/db"
+)
+
+// Supported bucket widths for HandleAggregate
+var aggregateIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// maxAggregateRows caps the (bucket, group) rows one request may return
+const maxAggregateRows = 10000
+
+// Payload keys that can be grouped on, e.g. api_call
+var payloadFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// AggregateResponse is the response of GET /api/v1/telemetry/aggregate
+type AggregateResponse struct {
+	BikeID   string            `json:"bike_id"`
+	Interval string            `json:"interval"`
+	GroupBy  string            `json:"group_by,omitempty"`
+	Buckets  []AggregateBucket `json:"buckets"`
+}
+
+// AggregateBucket holds val_primary statistics for one time bucket (and group).
+// Percentiles are exact nearest-rank (APIStat approximates the same with a sketch).
+type AggregateBucket struct {
+	Bucket string  `json:"bucket"` // Bucket start, RFC3339 (UTC)
+	Group  *string `json:"group,omitempty"`
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+}
+
+// HandleAggregate buckets a bike's telemetry by interval and returns count,
+// mean, min, max and percentiles of val_primary per bucket, computed in SQL.
+//
+// Accepts the same bike_id/from/to/type filters as HandleRead, plus
+// interval (1m, 5m, 1h, 1d; default 1h) and group_by, which is either
+// "log_type" or the name of a payload field (e.g. "api_call").
+func HandleAggregate(c *gin.Context) {
+	filter, err := parseTelemetryFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "1h")
+	width, ok := aggregateIntervals[interval]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be one of 1m, 5m, 1h, 1d"})
+		return
+	}
+
+	where, args := filter.where()
+	args = append(args, width.Seconds())
+	bucketExpr := fmt.Sprintf("to_timestamp(floor(extract(epoch FROM logged_at)::float8 / $%d::float8) * $%d::float8)", len(args), len(args))
+
+	groupBy := c.Query("group_by")
+	groupExpr := "NULL::text"
+	switch {
+	case groupBy == "":
+	case groupBy == "log_type":
+		groupExpr = "log_type"
+	case payloadFieldPattern.MatchString(groupBy):
+		args = append(args, groupBy)
+		groupExpr = fmt.Sprintf("COALESCE(payload->>$%d, 'unknown')", len(args))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be log_type or a payload field name"})
+		return
+	}
+
+	args = append(args, maxAggregateRows+1)
+	sql := fmt.Sprintf(`
+	SELECT %s AS bucket, %s AS grp,
+		COUNT(*),
+		COALESCE(AVG(val_primary), 0)::float8,
+		COALESCE(MIN(val_primary), 0),
+		COALESCE(MAX(val_primary), 0),
+		percentile_disc(ARRAY[0.5, 0.9, 0.95, 0.99]) WITHIN GROUP (ORDER BY val_primary)::float8[]
+	FROM telemetry_logs
+	WHERE %s
+	GROUP BY 1, 2
+	ORDER BY 1, 2
+	LIMIT $%d`, bucketExpr, groupExpr, where, len(args))
+
+	rows, err := db.Pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	buckets := []AggregateBucket{}
+	for rows.Next() {
+		var b AggregateBucket
+		var bucket time.Time
+		var pct []float64
+		if err := rows.Scan(&bucket, &b.Group, &b.Count, &b.Mean, &b.Min, &b.Max, &pct); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		b.Bucket = bucket.UTC().Format(time.RFC3339)
+		if len(pct) == 4 {
+			b.P50, b.P90, b.P95, b.P99 = pct[0], pct[1], pct[2], pct[3]
+		}
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	if len(buckets) > maxAggregateRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("more than %d buckets; narrow from/to or use a wider interval", maxAggregateRows)})
+		return
+	}
+
+	c.JSON(http.StatusOK, AggregateResponse{
+		BikeID:   filter.BikeID,
+		Interval: interval,
+		GroupBy:  groupBy,
+		Buckets:  buckets,
+	})
+}
@@ -4,23 +4,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
+	"slices"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"raptee-backend/db"
+	"raptee-backend/sketch"
 )
 
 // AnalyticsResponse is the top-level response structure
 type AnalyticsResponse struct {
-	BikeID       string                 `json:"bike_id"`
-	Summary      AnalyticsSummary       `json:"summary"`
-	APIStats     []APIStat              `json:"api_stats"`
-	Connectivity ConnectivityStats      `json:"connectivity_stats"`
-	Failures     []FailureIncident      `json:"failures"`
-	TimeSeries   []TimeSeriesPoint      `json:"time_series"`
+	BikeID       string            `json:"bike_id"`
+	Source       string            `json:"source"`         // "rollup_day", "rollup_hour" or "raw", as in fleet analytics
+	From         string            `json:"from,omitempty"` // Requested window, if any
+	To           string            `json:"to,omitempty"`
+	RawFrom      string            `json:"raw_from,omitempty"` // Raw reads (all stats for "raw", else failures and time series) start here: rows before it were pruned
+	Summary      AnalyticsSummary  `json:"summary"`
+	APIStats     []APIStat         `json:"api_stats"`
+	Connectivity ConnectivityStats `json:"connectivity_stats"`
+	Failures     []FailureIncident `json:"failures"`
+	TimeSeries   []TimeSeriesPoint `json:"time_series"`
+
+	// Failures and TimeSeries keep the latest maxAnalyticsDetail entries;
+	// these count all of them (per-bike only)
+	FailuresTotal   int `json:"failures_total,omitempty"`
+	TimeSeriesTotal int `json:"time_series_total,omitempty"`
 }
 
 type TimeSeriesPoint struct {
@@ -56,17 +68,74 @@ type APIStat struct {
 }
 
 type ConnectivityStats struct {
-	StateDistribution      map[string]int     `json:"state_distribution"`
-	FailureRateByState     map[string]float64 `json:"failure_rate_by_state"`
-	LatencyByState         map[string][]int   `json:"latency_by_state"` // For box plots
+	StateDistribution  map[string]int            `json:"state_distribution"`
+	FailureRateByState map[string]float64        `json:"failure_rate_by_state"`
+	LatencyByState     map[string]LatencySummary `json:"latency_by_state,omitempty"` // For box plots (per-bike only)
+}
+
+// LatencySummary describes the latencies of one connection state for a box
+// plot. Percentiles come from a sketch (within 1%); the rest is exact.
+type LatencySummary struct {
+	Count int     `json:"count"`
+	Min   int     `json:"min"`
+	P25   float64 `json:"p25"`
+	P50   float64 `json:"p50"`
+	P75   float64 `json:"p75"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   int     `json:"max"`
+	Mean  float64 `json:"mean"`
+}
+
+func summarizeLatencies(h *sketch.Histogram) LatencySummary {
+	return LatencySummary{
+		Count: h.Count(),
+		Min:   h.Min(),
+		P25:   h.Quantile(0.25),
+		P50:   h.Quantile(0.50),
+		P75:   h.Quantile(0.75),
+		P90:   h.Quantile(0.90),
+		P99:   h.Quantile(0.99),
+		Max:   h.Max(),
+		Mean:  h.Mean(),
+	}
 }
 
 type FailureIncident struct {
-	Timestamp   string `json:"timestamp"`
-	APIName     string `json:"api_name"`
-	StatusCode  int    `json:"status_code"`
-	Latency     int    `json:"latency"`
-	Type        string `json:"type"` // "Network Error", "Server Error", "High Latency", etc.
+	Timestamp  string `json:"timestamp"`
+	APIName    string `json:"api_name"`
+	StatusCode int    `json:"status_code"`
+	Latency    int    `json:"latency"`
+	Type       string `json:"type"` // "Network Error", "Server Error", "High Latency", etc.
+}
+
+// AnalyticsComparison is returned in compare mode: the requested window, the
+// baseline window and current-minus-baseline deltas
+type AnalyticsComparison struct {
+	Current  AnalyticsResponse `json:"current"`
+	Baseline AnalyticsResponse `json:"baseline"`
+	Delta    AnalyticsDelta    `json:"delta"`
+}
+
+// AnalyticsDelta holds current - baseline. Rates are in percentage points.
+type AnalyticsDelta struct {
+	TotalCalls       int            `json:"total_calls"`
+	SuccessRate      float64        `json:"success_rate"`
+	NetworkErrorRate float64        `json:"network_error_rate"`
+	ServerErrorRate  float64        `json:"server_error_rate"`
+	ClientErrorRate  float64        `json:"client_error_rate"`
+	APIStats         []APIStatDelta `json:"api_stats"` // APIs seen in both windows
+}
+
+type APIStatDelta struct {
+	APIName   string  `json:"api_name"`
+	Count     int     `json:"count"`
+	Mean      float64 `json:"mean"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50"`
+	P90       float64 `json:"p90"`
+	P95       float64 `json:"p95"`
+	P99       float64 `json:"p99"`
 }
 
 // Helper struct to parse the JSON payload from DB
@@ -74,233 +143,628 @@ type LogPayload struct {
 	APICall         string      `json:"api_call"`
 	StatusCode      interface{} `json:"status_code"` // Can be int or float or string in JSON
 	ConnectionState string      `json:"connection_state"`
+	NetworkType     string      `json:"network_type"`
 	SignalStrength  interface{} `json:"signal_strength"`
 }
 
+// apiCallFilter keeps only calls with the given (normalized) values; empty
+// fields match everything. It is applied after each payload is normalized, so
+// it matches the api_name / connection_state values the response reports
+// (including "unknown" and the URL fallback).
+type apiCallFilter struct {
+	APIName         string
+	ConnectionState string
+	NetworkType     string
+}
+
+func parseAPICallFilter(c *gin.Context) apiCallFilter {
+	return apiCallFilter{
+		APIName:         c.Query("api_name"),
+		ConnectionState: c.Query("connection_state"),
+		NetworkType:     c.Query("network_type"),
+	}
+}
+
+func (f apiCallFilter) matches(call apiCall) bool {
+	return (f.APIName == "" || f.APIName == call.APIName) &&
+		(f.ConnectionState == "" || f.ConnectionState == call.ConnectionState) &&
+		(f.NetworkType == "" || f.NetworkType == call.NetworkType)
+}
+
+// analyticsFilter narrows the API_LATENCY rows of one bike analytics are
+// computed over. The time range is applied in SQL.
+type analyticsFilter struct {
+	telemetryFilter
+	apiCallFilter
+}
+
+func parseAnalyticsFilter(c *gin.Context) (analyticsFilter, error) {
+	tf, err := parseTelemetryFilter(c)
+	if err != nil {
+		return analyticsFilter{}, err
+	}
+	tf.Types = []string{"API_LATENCY"}
+
+	return analyticsFilter{telemetryFilter: tf, apiCallFilter: parseAPICallFilter(c)}, nil
+}
+
+// parseCompareWindow returns the baseline filter for compare mode, which is
+// either compare=previous (the same-length window right before from/to) or
+// an explicit compare_from/compare_to. ok is false when not comparing.
+func parseCompareWindow(c *gin.Context, f analyticsFilter) (baseline analyticsFilter, ok bool, err error) {
+	mode := c.Query("compare")
+	explicit := c.Query("compare_from") != "" || c.Query("compare_to") != ""
+	if mode == "" && !explicit {
+		return f, false, nil
+	}
+
+	baseline = f
+	switch {
+	case explicit:
+		if baseline.From, err = parseTimeParam(c, "compare_from"); err != nil {
+			return f, false, err
+		}
+		if baseline.To, err = parseTimeParam(c, "compare_to"); err != nil {
+			return f, false, err
+		}
+		if baseline.From.IsZero() || baseline.To.IsZero() || !baseline.From.Before(baseline.To) {
+			return f, false, fmt.Errorf("compare_from and compare_to must both be set, with compare_from before compare_to")
+		}
+	case mode == "previous":
+		if f.From.IsZero() || f.To.IsZero() {
+			return f, false, fmt.Errorf("compare=previous needs both from and to")
+		}
+		baseline.To = f.From
+		baseline.From = f.From.Add(-f.To.Sub(f.From))
+	default:
+		return f, false, fmt.Errorf("compare must be previous (or use compare_from/compare_to)")
+	}
+	return baseline, true, nil
+}
+
+// HandleGetAnalytics returns API_LATENCY analytics for a bike, optionally for
+// a time window and a single API / connection state / network type. With
+// compare=previous or compare_from/compare_to it returns an AnalyticsComparison.
 func HandleGetAnalytics(c *gin.Context) {
-	bikeID := c.Query("bike_id")
-	if bikeID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "bike_id is required"})
+	filter, err := parseAnalyticsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	baselineFilter, compare, err := parseCompareWindow(c, filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := computeAnalytics(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
 		return
 	}
+	if !compare {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	baseline, err := computeAnalytics(c.Request.Context(), baselineFilter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, AnalyticsComparison{
+		Current:  resp,
+		Baseline: baseline,
+		Delta:    diffAnalytics(resp, baseline),
+	})
+}
+
+// computeAnalytics aggregates the API_LATENCY rows selected by f. Like fleet
+// analytics, it reads telemetry_rollups when rollupSource allows, and the
+// retained raw telemetry_logs otherwise.
+func computeAnalytics(ctx context.Context, f analyticsFilter) (AnalyticsResponse, error) {
+	acc := newAnalyticsAccumulator(true)
+	raw := f
+	var clamped bool
+	var err error
+	if raw.From, clamped, err = retainedFrom(ctx, f.From); err != nil {
+		return AnalyticsResponse{}, err
+	}
+
+	source := "raw"
+	if resolution, ok := rollupSource(f.From, f.To, f.NetworkType); ok {
+		source = "rollup_" + resolution
+		fleet := fleetFilter{BikeIDs: []string{f.BikeID}, From: f.From, To: f.To, apiCallFilter: f.apiCallFilter}
+		err = scanFleetRollups(ctx, fleet, resolution, acc.addRollup)
+		if err == nil {
+			err = addRecentDetail(ctx, raw, acc)
+		}
+	} else {
+		err = scanAnalyticsRows(ctx, raw, acc)
+	}
+	if err != nil {
+		return AnalyticsResponse{}, err
+	}
 
+	resp := acc.result()
+	resp.Source = source
+	resp.BikeID = f.BikeID
+	resp.From = formatBound(f.From)
+	resp.To = formatBound(f.To)
+	if clamped {
+		resp.RawFrom = formatBound(raw.From)
+	}
+	return resp, nil
+}
+
+// scanAnalyticsRows adds every raw call selected by f to acc
+func scanAnalyticsRows(ctx context.Context, f analyticsFilter, acc *analyticsAccumulator) error {
 	// Query Telemetry Logs for API_LATENCY
 	// We fetch val_primary (latency) and payload (metadata)
+	where, args := f.where()
 	sql := `SELECT logged_at, val_primary, payload 
 			FROM telemetry_logs 
-			WHERE bike_id = $1 AND log_type = 'API_LATENCY'
+			WHERE ` + where + `
 			ORDER BY logged_at ASC`
 
-	rows, err := db.Pool.Query(context.Background(), sql, bikeID)
+	rows, err := db.Pool.Query(ctx, sql, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
-		return
+		return err
 	}
 	defer rows.Close()
 
-	// Data Aggregation Structures
-	var summary AnalyticsSummary
-	var totalCalls, networkErrors, serverErrors, clientErrors, successCount int
-	
-	// Per API stats
-	apiLatencies := make(map[string][]int) // Success latencies only
-	apiAllCounts := make(map[string]int)
-	apiErrors := make(map[string]int)
-
-	// Connectivity
-	connStateCounts := make(map[string]int)
-	connStateFailures := make(map[string]int)
-	connStateLatencies := make(map[string][]int)
-
-	// Failures list
-	var failures []FailureIncident
-	var timeSeries []TimeSeriesPoint
-
 	for rows.Next() {
-		var loggedAt interface{} // Use interface to handle time scanning if needed, or just time.Time
+		var loggedAt time.Time
 		var latency int
 		var payloadBytes []byte
-		
-		// Note: logged_at is TIMESTAMPTZ, pgx scans to time.Time
+
 		if err := rows.Scan(&loggedAt, &latency, &payloadBytes); err != nil {
 			continue
 		}
-		
-		// Parse Payload
-		// The payload can be a JSON object (new format) or a JSON array (legacy/current format)
-		var p LogPayload
-		
-		// Try unmarshaling as a generic interface first to detect type
-		var rawPayload interface{}
-		if err := json.Unmarshal(payloadBytes, &rawPayload); err != nil {
-			fmt.Printf("Error unmarshaling payload for bike %s: %v\n", bikeID, err)
+		call, ok := parseAPICall(f.BikeID, payloadBytes)
+		if !ok || !f.matches(call) {
 			continue
 		}
+		acc.add(loggedAt, latency, call)
+	}
+	return rows.Err()
+}
 
-		switch v := rawPayload.(type) {
-		case map[string]interface{}:
-			// JSON Object
-			if val, ok := v["api_call"].(string); ok { p.APICall = val }
-			if val, ok := v["status_code"]; ok { p.StatusCode = val }
-			if val, ok := v["connection_state"].(string); ok { p.ConnectionState = val }
-			if val, ok := v["signal_strength"]; ok { p.SignalStrength = val }
-			
-		case []interface{}:
-			// JSON Array
-			// Format based on logs: ["url", "status_str", status_code, ?, ?, ?, "connection_state", "api_name", ?]
-			// Example: ["https://...", "success", 200, "", 0, "unknown", "WiFi", "charging_station", 0]
-			
-			if len(v) > 7 {
-				if val, ok := v[7].(string); ok { p.APICall = val }
+// failureCandidateSQL narrows raw rows to those that may be failure incidents
+// (not status 200, or over 20s). failureIncident makes the final call.
+const failureCandidateSQL = `(val_primary > 20000 OR
+	(CASE jsonb_typeof(payload) WHEN 'array' THEN payload->2 ELSE payload->'status_code' END) IS DISTINCT FROM '200'::jsonb)`
+
+// addRecentDetail fills in the failures and time series of an accumulator fed
+// from rollups, reading only the latest maxAnalyticsDetail raw calls of each
+// newest first, plus a count of the remaining failure candidates
+func addRecentDetail(ctx context.Context, f analyticsFilter, acc *analyticsAccumulator) error {
+	where, args := f.where()
+
+	var series []TimeSeriesPoint
+	err := scanRecent(ctx, f, `WHERE `+where, args, func(loggedAt time.Time, latency int, call apiCall) bool {
+		series = append(series, timeSeriesPoint(loggedAt, latency, call))
+		return len(series) < maxAnalyticsDetail
+	})
+	if err != nil {
+		return err
+	}
+
+	var failures []FailureIncident
+	total := 0
+	err = scanRecent(ctx, f, `WHERE `+where+` AND `+failureCandidateSQL, args, func(loggedAt time.Time, latency int, call apiCall) bool {
+		if incident, ok := failureIncident(loggedAt, latency, call); ok {
+			total++
+			if len(failures) < maxAnalyticsDetail {
+				failures = append(failures, incident)
 			}
-			if len(v) > 2 {
-				p.StatusCode = v[2]
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.Reverse(series)
+	slices.Reverse(failures)
+	acc.timeSeries, acc.seriesTotal = series, acc.totalCalls
+	acc.failures, acc.failuresTotal = failures, total
+	return nil
+}
+
+// scanRecent feeds the matching raw calls of a bike to fn, newest first,
+// until fn returns false. It reads pages of maxAnalyticsDetail rows, since
+// closing a half-read query would still transfer the rest of it.
+func scanRecent(ctx context.Context, f analyticsFilter, where string, args []interface{}, fn func(loggedAt time.Time, latency int, call apiCall) bool) error {
+	var afterAt time.Time
+	var afterID uuid.UUID
+	for {
+		pageWhere, pageArgs := where, args
+		if !afterAt.IsZero() {
+			pageArgs = append(append([]interface{}{}, args...), afterAt, afterID)
+			pageWhere += fmt.Sprintf(" AND (logged_at, log_id) < ($%d, $%d)", len(pageArgs)-1, len(pageArgs))
+		}
+		rows, err := db.Pool.Query(ctx, `SELECT logged_at, log_id, val_primary, payload FROM telemetry_logs `+pageWhere+`
+			ORDER BY logged_at DESC, log_id DESC LIMIT `+strconv.Itoa(maxAnalyticsDetail), pageArgs...)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		more := true
+		for rows.Next() && more {
+			var latency *int
+			var payloadBytes []byte
+			if err := rows.Scan(&afterAt, &afterID, &latency, &payloadBytes); err != nil {
+				rows.Close()
+				return err
 			}
-			if len(v) > 6 {
-				if val, ok := v[6].(string); ok { p.ConnectionState = val }
+			n++
+			if latency == nil {
+				continue
 			}
-			// Signal strength index is unknown, leaving empty for now or guessing index 8?
-			// Let's assume index 8 might be signal strength if it's a number, but user log has 0 there.
-			
-		default:
-			fmt.Printf("Unknown payload format for bike %s: %T\n", bikeID, rawPayload)
-			continue
+			call, ok := parseAPICall(f.BikeID, payloadBytes)
+			if !ok || !f.matches(call) {
+				continue
+			}
+			more = fn(afterAt, *latency, call)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || !more || n < maxAnalyticsDetail {
+			return err
 		}
+	}
+}
+
+// apiCall is an API_LATENCY payload normalized for aggregation
+type apiCall struct {
+	APIName         string
+	StatusCode      int // -1 if missing or not numeric
+	ConnectionState string
+	NetworkType     string
+	SignalStrength  int
+}
 
-		// Normalize Status Code
-		statusCode := -1
-		switch v := p.StatusCode.(type) {
-		case float64:
-			statusCode = int(v)
-		case int:
-			statusCode = v
-		case string:
-			// Try to parse string? Or just default to -1
-		}
-
-		// Normalize API Name
-		apiName := p.APICall
-		if apiName == "" {
-			// Fallback to URL if API name is missing (Index 0 in array)
-			if arr, ok := rawPayload.([]interface{}); ok && len(arr) > 0 {
-				if url, ok := arr[0].(string); ok {
-					apiName = url // Use URL as fallback
-				}
+// parseAPICall normalizes an API_LATENCY payload. ok is false (and the row
+// should be skipped) when the payload is not a JSON object or array.
+func parseAPICall(bikeID string, payloadBytes []byte) (apiCall, bool) {
+	// Parse Payload
+	// The payload can be a JSON object (new format) or a JSON array (legacy/current format)
+	var p LogPayload
+
+	// Try unmarshaling as a generic interface first to detect type
+	var rawPayload interface{}
+	if err := json.Unmarshal(payloadBytes, &rawPayload); err != nil {
+		fmt.Printf("Error unmarshaling payload for bike %s: %v\n", bikeID, err)
+		return apiCall{}, false
+	}
+
+	switch v := rawPayload.(type) {
+	case map[string]interface{}:
+		// JSON Object
+		if val, ok := v["api_call"].(string); ok {
+			p.APICall = val
+		}
+		if val, ok := v["status_code"]; ok {
+			p.StatusCode = val
+		}
+		if val, ok := v["connection_state"].(string); ok {
+			p.ConnectionState = val
+		}
+		if val, ok := v["network_type"].(string); ok {
+			p.NetworkType = val
+		}
+		if val, ok := v["signal_strength"]; ok {
+			p.SignalStrength = val
+		}
+
+	case []interface{}:
+		// JSON Array
+		// Format based on logs: ["url", "status_str", status_code, ?, ?, ?, "connection_state", "api_name", ?]
+		// Example: ["https://...", "success", 200, "", 0, "unknown", "WiFi", "charging_station", 0]
+
+		if len(v) > 7 {
+			if val, ok := v[7].(string); ok {
+				p.APICall = val
 			}
-			if apiName == "" {
-				apiName = "unknown"
+		}
+		if len(v) > 2 {
+			p.StatusCode = v[2]
+		}
+		if len(v) > 6 {
+			if val, ok := v[6].(string); ok {
+				p.ConnectionState = val
+				p.NetworkType = val
 			}
 		}
+		// Signal strength index is unknown, leaving empty for now or guessing index 8?
+		// Let's assume index 8 might be signal strength if it's a number, but user log has 0 there.
+
+	default:
+		fmt.Printf("Unknown payload format for bike %s: %T\n", bikeID, rawPayload)
+		return apiCall{}, false
+	}
 
-		// Normalize Connection State
-		connState := p.ConnectionState
-		if connState == "" {
-			connState = "unknown"
-		}
-
-		// --- Aggregation Logic ---
-		totalCalls++
-		apiAllCounts[apiName]++
-		connStateCounts[connState]++
-		connStateLatencies[connState] = append(connStateLatencies[connState], latency)
-
-		isSuccess := statusCode == 200
-		isNetworkError := statusCode == 0
-		isServerError := statusCode >= 500 && statusCode < 600
-		isClientError := statusCode >= 400 && statusCode < 500
-
-		if isSuccess {
-			successCount++
-			apiLatencies[apiName] = append(apiLatencies[apiName], latency)
-		} else {
-			apiErrors[apiName]++
-			connStateFailures[connState]++
-			
-			if isNetworkError {
-				networkErrors++
-			} else if isServerError {
-				serverErrors++
-			} else if isClientError {
-				clientErrors++
+	call := apiCall{
+		APIName:         p.APICall,
+		StatusCode:      -1,
+		ConnectionState: p.ConnectionState,
+		NetworkType:     p.NetworkType,
+	}
+
+	// Normalize Status Code
+	switch v := p.StatusCode.(type) {
+	case float64:
+		call.StatusCode = int(v)
+	case int:
+		call.StatusCode = v
+	case string:
+		// Try to parse string? Or just default to -1
+	}
+
+	// Normalize API Name
+	if call.APIName == "" {
+		// Fallback to URL if API name is missing (Index 0 in array)
+		if arr, ok := rawPayload.([]interface{}); ok && len(arr) > 0 {
+			if url, ok := arr[0].(string); ok {
+				call.APIName = url // Use URL as fallback
 			}
 		}
+		if call.APIName == "" {
+			call.APIName = "unknown"
+		}
+	}
 
-		// Failure / Incident Tracking
-		// Condition: Non-200 OR High Latency (> 20s)
-		// Format timestamp
-		tsStr := ""
-		if t, ok := loggedAt.(time.Time); ok {
-			tsStr = t.Format(time.RFC3339)
-		} else {
-			tsStr = fmt.Sprintf("%v", loggedAt)
-		}
-
-		// Failure / Incident Tracking
-		// Condition: Non-200 OR High Latency (> 20s)
-		if !isSuccess || latency > 20000 {
-			incidentType := "Other Error"
-			if isNetworkError {
-				incidentType = "Network Error (0)"
-			} else if isServerError {
-				incidentType = "Server Error"
-			} else if isClientError {
-				incidentType = "Client Error"
-			} else if latency > 20000 {
-				incidentType = "High Latency (>20s)"
-			}
-			
-			failures = append(failures, FailureIncident{
-				Timestamp:  tsStr,
-				APIName:    apiName,
-				StatusCode: statusCode,
-				Latency:    latency,
-				Type:       incidentType,
-			})
-		}
-		
-		// Capture Time Range
-		if summary.StartTime == "" {
-			summary.StartTime = tsStr
-		}
-		summary.EndTime = tsStr
-
-		// Populate TimeSeries
-		// Normalize Signal Strength
-		sigStrength := 0
-		switch v := p.SignalStrength.(type) {
-		case float64:
-			sigStrength = int(v)
-		case int:
-			sigStrength = v
-		}
-
-		timeSeries = append(timeSeries, TimeSeriesPoint{
-			Timestamp:       tsStr,
-			Latency:         latency,
-			APIName:         apiName,
-			Status:          statusCode,
-			SignalStrength:  sigStrength,
-			ConnectionState: connState,
-		})
+	// Normalize Connection State / Network Type
+	if call.ConnectionState == "" {
+		call.ConnectionState = "unknown"
+	}
+	if call.NetworkType == "" {
+		call.NetworkType = "unknown"
+	}
+
+	// Normalize Signal Strength
+	switch v := p.SignalStrength.(type) {
+	case float64:
+		call.SignalStrength = int(v)
+	case int:
+		call.SignalStrength = v
+	}
+
+	return call, true
+}
+
+// analyticsAccumulator folds API calls into an AnalyticsResponse. Without
+// detail it skips the per-row lists (failures, time series, latency by
+// state), which fleet-wide queries can't afford to return.
+type analyticsAccumulator struct {
+	detail bool
+
+	// Data Aggregation Structures
+	start, end                                                          time.Time
+	totalCalls, networkErrors, serverErrors, clientErrors, successCount int
+
+	// Per API stats
+	apiLatencies map[string]*sketch.Histogram // Success latencies only
+	apiAllCounts map[string]int
+	apiErrors    map[string]int
+
+	// Connectivity
+	connStateCounts    map[string]int
+	connStateFailures  map[string]int
+	connStateLatencies map[string]*sketch.Histogram // Success latencies, per-bike only
+
+	// Failures list, the latest maxAnalyticsDetail of each (see keepLatest)
+	failures                   []FailureIncident
+	timeSeries                 []TimeSeriesPoint
+	failuresTotal, seriesTotal int
+}
+
+// maxAnalyticsDetail caps the failures and time series a response returns,
+// so a bike with years of calls can't exhaust memory
+const maxAnalyticsDetail = 10000
+
+// keepLatest appends v, holding at most 2*maxAnalyticsDetail entries by
+// dropping the oldest half when full; latest trims to the final window
+func keepLatest[T any](s []T, v T) []T {
+	if len(s) == 2*maxAnalyticsDetail {
+		s = append(s[:0], s[maxAnalyticsDetail:]...)
+	}
+	return append(s, v)
+}
+
+func latest[T any](s []T) []T {
+	if len(s) > maxAnalyticsDetail {
+		return s[len(s)-maxAnalyticsDetail:]
+	}
+	return s
+}
+
+func newAnalyticsAccumulator(detail bool) *analyticsAccumulator {
+	return &analyticsAccumulator{
+		detail:             detail,
+		apiLatencies:       make(map[string]*sketch.Histogram),
+		apiAllCounts:       make(map[string]int),
+		apiErrors:          make(map[string]int),
+		connStateCounts:    make(map[string]int),
+		connStateFailures:  make(map[string]int),
+		connStateLatencies: make(map[string]*sketch.Histogram),
+	}
+}
+
+// add counts one call. It reports whether the call succeeded (status 200).
+func (a *analyticsAccumulator) add(loggedAt time.Time, latency int, call apiCall) bool {
+	apiName, connState, statusCode := call.APIName, call.ConnectionState, call.StatusCode
+
+	// --- Aggregation Logic ---
+	a.totalCalls++
+	a.apiAllCounts[apiName]++
+	a.connStateCounts[connState]++
+
+	isSuccess, isNetworkError, isServerError, isClientError := classifyStatus(statusCode)
+
+	if isSuccess {
+		a.successCount++
+		a.latencies(apiName).Add(latency)
+		if a.detail {
+			a.stateLatencies(connState).Add(latency)
+		}
+	} else {
+		a.apiErrors[apiName]++
+		a.connStateFailures[connState]++
+
+		if isNetworkError {
+			a.networkErrors++
+		} else if isServerError {
+			a.serverErrors++
+		} else if isClientError {
+			a.clientErrors++
+		}
 	}
 
-	// --- Final Calculations ---
+	a.extendRange(loggedAt, loggedAt)
 
+	if a.detail {
+		a.addDetail(loggedAt, latency, call)
+	}
+	return isSuccess
+}
+
+// addDetail adds a call to the time series, and to the failures if it is one
+func (a *analyticsAccumulator) addDetail(loggedAt time.Time, latency int, call apiCall) {
+	if incident, ok := failureIncident(loggedAt, latency, call); ok {
+		a.failuresTotal++
+		a.failures = keepLatest(a.failures, incident)
+	}
+	a.seriesTotal++
+	a.timeSeries = keepLatest(a.timeSeries, timeSeriesPoint(loggedAt, latency, call))
+}
+
+// failureIncident describes a call that failed or took over 20s. ok is false
+// for any other call.
+func failureIncident(loggedAt time.Time, latency int, call apiCall) (FailureIncident, bool) {
+	isSuccess, isNetworkError, isServerError, isClientError := classifyStatus(call.StatusCode)
+
+	// Failure / Incident Tracking
+	// Condition: Non-200 OR High Latency (> 20s)
+	if isSuccess && latency <= 20000 {
+		return FailureIncident{}, false
+	}
+	incidentType := "Other Error"
+	if isNetworkError {
+		incidentType = "Network Error (0)"
+	} else if isServerError {
+		incidentType = "Server Error"
+	} else if isClientError {
+		incidentType = "Client Error"
+	} else if latency > 20000 {
+		incidentType = "High Latency (>20s)"
+	}
+
+	return FailureIncident{
+		Timestamp:  loggedAt.Format(time.RFC3339),
+		APIName:    call.APIName,
+		StatusCode: call.StatusCode,
+		Latency:    latency,
+		Type:       incidentType,
+	}, true
+}
+
+func timeSeriesPoint(loggedAt time.Time, latency int, call apiCall) TimeSeriesPoint {
+	return TimeSeriesPoint{
+		Timestamp:       loggedAt.Format(time.RFC3339),
+		Latency:         latency,
+		APIName:         call.APIName,
+		Status:          call.StatusCode,
+		SignalStrength:  call.SignalStrength,
+		ConnectionState: call.ConnectionState,
+	}
+}
+
+// addRollup counts a pre-aggregated rollup row in place of its raw calls.
+// The failures and time series are not available from rollups (see
+// addRecentDetail); latency by state is.
+func (a *analyticsAccumulator) addRollup(r rollupRow) {
+	failed := r.Total - r.Success
+
+	a.totalCalls += r.Total
+	a.successCount += r.Success
+	a.networkErrors += r.NetworkErrors
+	a.serverErrors += r.ServerErrors
+	a.clientErrors += r.ClientErrors
+
+	a.apiAllCounts[r.APICall] += r.Total
+	a.apiErrors[r.APICall] += failed
+	a.connStateCounts[r.ConnectionState] += r.Total
+	a.connStateFailures[r.ConnectionState] += failed
+	if r.Success > 0 {
+		a.latencies(r.APICall).Merge(r.Latencies)
+		if a.detail {
+			a.stateLatencies(r.ConnectionState).Merge(r.Latencies)
+		}
+	}
+
+	a.extendRange(r.FirstAt, r.LastAt)
+}
+
+// latencies returns the success latency sketch of an API, creating it
+func (a *analyticsAccumulator) latencies(apiName string) *sketch.Histogram {
+	h := a.apiLatencies[apiName]
+	if h == nil {
+		h = sketch.New()
+		a.apiLatencies[apiName] = h
+	}
+	return h
+}
+
+// stateLatencies returns the success latency sketch of a connection state,
+// creating it
+func (a *analyticsAccumulator) stateLatencies(state string) *sketch.Histogram {
+	h := a.connStateLatencies[state]
+	if h == nil {
+		h = sketch.New()
+		a.connStateLatencies[state] = h
+	}
+	return h
+}
+
+func (a *analyticsAccumulator) extendRange(first, last time.Time) {
+	if a.start.IsZero() || first.Before(a.start) {
+		a.start = first
+	}
+	if last.After(a.end) {
+		a.end = last
+	}
+}
+
+// classifyStatus sorts a normalized status code into the analytics classes.
+// Anything else (e.g. 3xx, or -1 for a missing code) is an "other" failure.
+func classifyStatus(code int) (success, network, server, client bool) {
+	return code == 200, code == 0, code >= 500 && code < 600, code >= 400 && code < 500
+}
+
+// result runs the final calculations. BikeID and the window are left to the caller.
+func (a *analyticsAccumulator) result() AnalyticsResponse {
 	// 1. Summary
-	summary.TotalCalls = totalCalls
-	if totalCalls > 0 {
-		summary.SuccessRate = float64(successCount) / float64(totalCalls) * 100
-		summary.NetworkErrorRate = float64(networkErrors) / float64(totalCalls) * 100
-		summary.ServerErrorRate = float64(serverErrors) / float64(totalCalls) * 100
-		summary.ClientErrorRate = float64(clientErrors) / float64(totalCalls) * 100
+	var summary AnalyticsSummary
+	if !a.start.IsZero() {
+		summary.StartTime = a.start.Format(time.RFC3339)
+		summary.EndTime = a.end.Format(time.RFC3339)
+	}
+	summary.TotalCalls = a.totalCalls
+	if a.totalCalls > 0 {
+		summary.SuccessRate = float64(a.successCount) / float64(a.totalCalls) * 100
+		summary.NetworkErrorRate = float64(a.networkErrors) / float64(a.totalCalls) * 100
+		summary.ServerErrorRate = float64(a.serverErrors) / float64(a.totalCalls) * 100
+		summary.ClientErrorRate = float64(a.clientErrors) / float64(a.totalCalls) * 100
 	}
 
 	// 2. API Stats
 	var apiStats []APIStat
-	for api, latencies := range apiLatencies {
-		count := apiAllCounts[api]
-		errCount := apiErrors[api]
-		
+	for api, latencies := range a.apiLatencies {
+		count := a.apiAllCounts[api]
+		errCount := a.apiErrors[api]
+
 		stat := APIStat{
 			APIName: api,
 			Count:   count,
@@ -310,37 +774,23 @@ func HandleGetAnalytics(c *gin.Context) {
 			stat.ErrorRate = float64(errCount) / float64(count) * 100
 		}
 
-		if len(latencies) > 0 {
-			// Sort for percentiles
-			sort.Ints(latencies)
-			
-			var sum int
-			for _, l := range latencies {
-				sum += l
-			}
-			stat.Mean = float64(sum) / float64(len(latencies))
-			stat.Min = latencies[0]
-			stat.Max = latencies[len(latencies)-1]
-			
-			stat.P50 = getPercentile(latencies, 0.50)
-			stat.P90 = getPercentile(latencies, 0.90)
-			stat.P95 = getPercentile(latencies, 0.95)
-			stat.P99 = getPercentile(latencies, 0.99)
-		}
-		
+		if latencies.Count() > 0 {
+			stat.Mean = latencies.Mean()
+			stat.Min = latencies.Min()
+			stat.Max = latencies.Max()
+
+			stat.P50 = latencies.Quantile(0.50)
+			stat.P90 = latencies.Quantile(0.90)
+			stat.P95 = latencies.Quantile(0.95)
+			stat.P99 = latencies.Quantile(0.99)
+		}
+
 		apiStats = append(apiStats, stat)
 	}
-	
+
 	// Add APIs that had 0 successes (only errors)
-	for api, count := range apiAllCounts {
-		found := false
-		for _, s := range apiStats {
-			if s.APIName == api {
-				found = true
-				break
-			}
-		}
-		if !found {
+	for api, count := range a.apiAllCounts {
+		if _, found := a.apiLatencies[api]; !found {
 			// Only errors
 			stat := APIStat{
 				APIName:   api,
@@ -353,40 +803,66 @@ func HandleGetAnalytics(c *gin.Context) {
 
 	// 3. Connectivity Stats
 	connStats := ConnectivityStats{
-		StateDistribution:  connStateCounts,
+		StateDistribution:  a.connStateCounts,
 		FailureRateByState: make(map[string]float64),
-		LatencyByState:     connStateLatencies,
 	}
-	
-	for state, count := range connStateCounts {
-		fails := connStateFailures[state]
+	if a.detail {
+		connStats.LatencyByState = make(map[string]LatencySummary, len(a.connStateLatencies))
+		for state, h := range a.connStateLatencies {
+			connStats.LatencyByState[state] = summarizeLatencies(h)
+		}
+	}
+
+	for state, count := range a.connStateCounts {
+		fails := a.connStateFailures[state]
 		if count > 0 {
 			connStats.FailureRateByState[state] = float64(fails) / float64(count) * 100
 		}
 	}
 
-	resp := AnalyticsResponse{
-		BikeID:       bikeID,
-		Summary:      summary,
-		APIStats:     apiStats,
-		Connectivity: connStats,
-		Failures:     failures,
-		TimeSeries:   timeSeries,
+	return AnalyticsResponse{
+		Summary:         summary,
+		APIStats:        apiStats,
+		Connectivity:    connStats,
+		Failures:        latest(a.failures),
+		TimeSeries:      latest(a.timeSeries),
+		FailuresTotal:   a.failuresTotal,
+		TimeSeriesTotal: a.seriesTotal,
 	}
-
-	c.JSON(http.StatusOK, resp)
 }
 
-func getPercentile(sorted []int, p float64) float64 {
-	if len(sorted) == 0 {
-		return 0
+// diffAnalytics returns current - baseline for the summary rates and for the
+// stats of every API present in both windows
+func diffAnalytics(current, baseline AnalyticsResponse) AnalyticsDelta {
+	d := AnalyticsDelta{
+		TotalCalls:       current.Summary.TotalCalls - baseline.Summary.TotalCalls,
+		SuccessRate:      current.Summary.SuccessRate - baseline.Summary.SuccessRate,
+		NetworkErrorRate: current.Summary.NetworkErrorRate - baseline.Summary.NetworkErrorRate,
+		ServerErrorRate:  current.Summary.ServerErrorRate - baseline.Summary.ServerErrorRate,
+		ClientErrorRate:  current.Summary.ClientErrorRate - baseline.Summary.ClientErrorRate,
+		APIStats:         []APIStatDelta{},
 	}
-	idx := int(math.Ceil(float64(len(sorted))*p)) - 1
-	if idx < 0 {
-		idx = 0
+
+	base := make(map[string]APIStat, len(baseline.APIStats))
+	for _, s := range baseline.APIStats {
+		base[s.APIName] = s
 	}
-	if idx >= len(sorted) {
-		idx = len(sorted) - 1
+	for _, cur := range current.APIStats {
+		b, ok := base[cur.APIName]
+		if !ok {
+			continue
+		}
+		d.APIStats = append(d.APIStats, APIStatDelta{
+			APIName:   cur.APIName,
+			Count:     cur.Count - b.Count,
+			Mean:      cur.Mean - b.Mean,
+			ErrorRate: cur.ErrorRate - b.ErrorRate,
+			P50:       cur.P50 - b.P50,
+			P90:       cur.P90 - b.P90,
+			P95:       cur.P95 - b.P95,
+			P99:       cur.P99 - b.P99,
+		})
 	}
-	return float64(sorted[idx])
+	sort.Slice(d.APIStats, func(i, j int) bool { return d.APIStats[i].APIName < d.APIStats[j].APIName })
+	return d
 }
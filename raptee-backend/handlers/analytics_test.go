@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// Detail lists must stay bounded however many calls a bike has, keeping the
+// latest ones, and latency by state must summarize the successful calls
+func TestAnalyticsAccumulatorBounded(t *testing.T) {
+	acc := newAnalyticsAccumulator(true)
+	start := time.Date(2025, 11, 28, 0, 0, 0, 0, time.UTC)
+	n := 2*maxAnalyticsDetail + 1234
+	failures := 0
+	for i := 0; i < n; i++ {
+		status := 200
+		if i%2 == 1 {
+			status = 500
+			failures++
+		}
+		acc.add(start.Add(time.Duration(i)*time.Second), i%1000, apiCall{
+			APIName: "charging_station", StatusCode: status, ConnectionState: "WiFi", NetworkType: "WiFi",
+		})
+	}
+	if len(acc.failures) > 2*maxAnalyticsDetail || len(acc.timeSeries) > 2*maxAnalyticsDetail {
+		t.Fatalf("detail lists grew to %d/%d entries", len(acc.failures), len(acc.timeSeries))
+	}
+
+	resp := acc.result()
+	if len(resp.Failures) != maxAnalyticsDetail || len(resp.TimeSeries) != maxAnalyticsDetail {
+		t.Fatalf("expected %d failures and points, got %d and %d", maxAnalyticsDetail, len(resp.Failures), len(resp.TimeSeries))
+	}
+	if resp.FailuresTotal != failures || resp.TimeSeriesTotal != n {
+		t.Errorf("expected totals of %d and %d, got %d and %d", failures, n, resp.FailuresTotal, resp.TimeSeriesTotal)
+	}
+	last := start.Add(time.Duration(n-1) * time.Second).Format(time.RFC3339)
+	if got := resp.TimeSeries[len(resp.TimeSeries)-1].Timestamp; got != last {
+		t.Errorf("expected the latest point at %s, got %s", last, got)
+	}
+
+	s, ok := resp.Connectivity.LatencyByState["WiFi"]
+	if !ok || s.Count != n-failures || s.Min != 0 || s.Max != 998 || s.P25 > s.P50 || s.P50 > s.P75 {
+		t.Errorf("unexpected WiFi latency summary %+v", s)
+	}
+}
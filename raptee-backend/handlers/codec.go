@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ugorji/go/codec"
+	"raptee-backend/models"
+)
+
+// Upper bound on a decompressed sync body (guards against compression bombs)
+const maxSyncBodyBytes = 32 << 20
+
+// Supported sync body formats (negotiated on Content-Type)
+const (
+	formatJSON    = "JSON"
+	formatMsgpack = "MessagePack"
+	formatCBOR    = "CBOR"
+)
+
+var (
+	msgpackHandle = newMsgpackHandle()
+	cborHandle    = newCborHandle()
+
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errBodyTooLarge         = fmt.Errorf("body exceeds %d MB", maxSyncBodyBytes>>20)
+)
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	return h
+}
+
+func newCborHandle() *codec.CborHandle {
+	h := &codec.CborHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+// bindCompactRequest decodes a sync body into req, honouring Content-Encoding
+// (gzip, zstd) and Content-Type (JSON, MessagePack, CBOR). Binary values are
+// normalized to the shapes encoding/json produces (float64 numbers, string-keyed
+// maps) so the rest of the pipeline is format agnostic.
+//
+// Returns the detected format name when the body itself failed to decode (for
+// error messages), errUnsupportedMediaType (wrapped) when the body can't be
+// negotiated at all, and errBodyTooLarge past maxSyncBodyBytes decompressed.
+func bindCompactRequest(c *gin.Context, req *models.CompactRequest) (string, error) {
+	format, err := syncFormat(c.ContentType())
+	if err != nil {
+		return "", err
+	}
+
+	body, err := decompressBody(c.GetHeader("Content-Encoding"), c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	limited := &maxBytesReader{r: body, n: maxSyncBodyBytes}
+
+	switch format {
+	case formatMsgpack:
+		err = codec.NewDecoder(limited, msgpackHandle).Decode(req)
+	case formatCBOR:
+		err = codec.NewDecoder(limited, cborHandle).Decode(req)
+	default:
+		err = json.NewDecoder(limited).Decode(req)
+	}
+	if limited.exceeded {
+		return "", errBodyTooLarge
+	}
+	if err != nil {
+		return format, err
+	}
+
+	for _, row := range req.Data {
+		for i, v := range row {
+			row[i] = normalizeValue(v)
+		}
+	}
+	return format, nil
+}
+
+// maxBytesReader reads at most n bytes of r, then fails (and records it)
+// instead of ending early, so an oversized body can't pass as a short one
+type maxBytesReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n <= 0 {
+		// Anything left beyond the limit means the body is too large
+		var probe [1]byte
+		if n, _ := m.r.Read(probe[:]); n > 0 {
+			m.exceeded = true
+			return 0, errBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > m.n {
+		p = p[:m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	return n, err
+}
+
+func syncFormat(contentType string) (string, error) {
+	switch strings.ToLower(contentType) {
+	case "", "application/json":
+		return formatJSON, nil
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return formatMsgpack, nil
+	case "application/cbor":
+		return formatCBOR, nil
+	}
+	return "", fmt.Errorf("%w: Content-Type %q", errUnsupportedMediaType, contentType)
+}
+
+func decompressBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		return gzip.NewReader(body)
+	case "zstd":
+		dec, err := zstd.NewReader(body, zstd.WithDecoderMaxMemory(maxSyncBodyBytes))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: Content-Encoding %q", errUnsupportedMediaType, encoding)
+}
+
+// normalizeValue converts decoded binary values to their encoding/json equivalents
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case int64:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case []byte:
+		return string(val)
+	case time.Time: // CBOR time tags, MessagePack timestamps
+		return val.UTC().Format(time.RFC3339Nano)
+	case []interface{}:
+		for i := range val {
+			val[i] = normalizeValue(val[i])
+		}
+		return val
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = normalizeValue(val[k])
+		}
+		return val
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[fmt.Sprint(k)] = normalizeValue(item)
+		}
+		return m
+	}
+	return v
+}
+
+// bindErrorStatus maps a bindCompactRequest error to an HTTP status
+func bindErrorStatus(err error) int {
+	if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"raptee-backend/db"
+)
+
+// exportFlushEvery is how many points are written between flushes to the client
+const exportFlushEvery = 500
+
+// exportPoint is one located telemetry row being exported
+type exportPoint struct {
+	LogID      string
+	LoggedAt   time.Time
+	LogType    string
+	ValPrimary *int
+	Payload    []byte
+	GPSFlag    *string
+	Typed      []*float64 // Values of typedColumns
+	Lat, Lng   float64
+}
+
+// altitudeColumn is altitude's index in exportPoint.Typed, for GPX <ele>
+var altitudeColumn = typedColumnIndex("altitude")
+
+// exportWriter renders points in one export format
+type exportWriter interface {
+	begin(w *bufio.Writer, bikeID string) error
+	point(w *bufio.Writer, p exportPoint) error
+	end(w *bufio.Writer) error
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+	writer      func() exportWriter
+}{
+	"geojson": {"application/geo+json", "geojson", func() exportWriter { return &geoJSONWriter{} }},
+	"gpx":     {"application/gpx+xml", "gpx", func() exportWriter { return &gpxWriter{} }},
+}
+
+// HandleExport streams a bike's located telemetry, oldest first, as a GeoJSON
+// FeatureCollection (format=geojson, the default) or a GPX track (format=gpx).
+// It takes the bike_id/from/to/type filters of HandleRead. Rows are written as
+// they are read, so exports of any size use constant memory.
+func HandleExport(c *gin.Context) {
+	filter, err := parseTelemetryFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format, ok := exportFormats[c.DefaultQuery("format", "geojson")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be geojson or gpx"})
+		return
+	}
+
+	where, args := filter.where()
+	sql := `SELECT log_id::text, logged_at, log_type, val_primary, payload, gps_flag,
+			ST_Y(location::geometry), ST_X(location::geometry), ` + strings.Join(typedColumnNames(), ", ") + `
+		FROM telemetry_logs
+		WHERE ` + where + ` AND location IS NOT NULL
+		ORDER BY logged_at, log_id`
+
+	rows, err := db.Pool.Query(c.Request.Context(), sql, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", format.contentType)
+	// FormatMediaType quotes or RFC 2231-encodes the name, so a bike_id with
+	// quotes, semicolons or line breaks can't break out of the header
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filter.BikeID + "." + format.extension,
+	}))
+	c.Status(http.StatusOK)
+
+	// Headers are sent now; later failures can only cut the export short
+	w := bufio.NewWriter(c.Writer)
+	out := format.writer()
+	fail := func(err error) {
+		log.Printf("Export for %s aborted: %v", filter.BikeID, err)
+	}
+	if err := out.begin(w, filter.BikeID); err != nil {
+		fail(err)
+		return
+	}
+
+	n := 0
+	for rows.Next() {
+		p := exportPoint{Typed: make([]*float64, len(typedColumns))}
+		dest := []interface{}{&p.LogID, &p.LoggedAt, &p.LogType, &p.ValPrimary, &p.Payload, &p.GPSFlag, &p.Lat, &p.Lng}
+		for i := range p.Typed {
+			dest = append(dest, &p.Typed[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			fail(err)
+			return
+		}
+		if err := out.point(w, p); err != nil {
+			fail(err)
+			return
+		}
+		if n++; n%exportFlushEvery == 0 {
+			if err := w.Flush(); err != nil {
+				fail(err)
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fail(err)
+		return
+	}
+	if err := out.end(w); err != nil {
+		fail(err)
+		return
+	}
+	if err := w.Flush(); err != nil {
+		fail(err)
+	}
+}
+
+// geoJSONWriter writes a FeatureCollection of Point features. Properties are
+// log_id, logged_at, log_type, val_primary, gps_flag and the typed columns,
+// plus the payload's fields (an array payload is kept whole under "payload").
+type geoJSONWriter struct {
+	wrote bool
+}
+
+func (g *geoJSONWriter) begin(w *bufio.Writer, bikeID string) error {
+	name, _ := json.Marshal(bikeID)
+	_, err := fmt.Fprintf(w, `{"type":"FeatureCollection","name":%s,"features":[`, name)
+	return err
+}
+
+func (g *geoJSONWriter) point(w *bufio.Writer, p exportPoint) error {
+	props := map[string]interface{}{}
+	if len(p.Payload) > 0 {
+		var payload interface{}
+		if err := json.Unmarshal(p.Payload, &payload); err == nil {
+			if obj, ok := payload.(map[string]interface{}); ok {
+				props = obj
+			} else {
+				props["payload"] = payload
+			}
+		}
+	}
+	props["log_id"] = p.LogID
+	props["logged_at"] = p.LoggedAt.UTC().Format(time.RFC3339)
+	props["log_type"] = p.LogType
+	props["val_primary"] = p.ValPrimary
+	props["gps_flag"] = p.GPSFlag
+	for i, tc := range typedColumns {
+		props[tc.Name] = p.Typed[i]
+	}
+
+	feature, err := json.Marshal(map[string]interface{}{
+		"type":       "Feature",
+		"geometry":   map[string]interface{}{"type": "Point", "coordinates": []float64{p.Lng, p.Lat}},
+		"properties": props,
+	})
+	if err != nil {
+		return err
+	}
+	if g.wrote {
+		w.WriteByte(',')
+	}
+	g.wrote = true
+	_, err = w.Write(feature)
+	return err
+}
+
+func (g *geoJSONWriter) end(w *bufio.Writer) error {
+	_, err := w.WriteString("]}")
+	return err
+}
+
+// gpxWriter writes one GPX 1.1 track, starting a new segment wherever the
+// points are more than tripMaxGap apart (as trips do). Rows with an altitude
+// get an <ele>.
+type gpxWriter struct {
+	last time.Time
+}
+
+func (g *gpxWriter) begin(w *bufio.Writer, bikeID string) error {
+	w.WriteString(xml.Header)
+	w.WriteString(`<gpx version="1.1" creator="raptee-backend" xmlns="http://www.topografix.com/GPX/1/1"><trk><name>`)
+	if err := xml.EscapeText(w, []byte(bikeID)); err != nil {
+		return err
+	}
+	_, err := w.WriteString("</name><trkseg>")
+	return err
+}
+
+func (g *gpxWriter) point(w *bufio.Writer, p exportPoint) error {
+	if !g.last.IsZero() && p.LoggedAt.Sub(g.last) > tripMaxGap {
+		w.WriteString("</trkseg><trkseg>")
+	}
+	g.last = p.LoggedAt
+
+	if _, err := fmt.Fprintf(w, `<trkpt lat="%.7f" lon="%.7f">`, p.Lat, p.Lng); err != nil {
+		return err
+	}
+	if ele := p.Typed[altitudeColumn]; ele != nil {
+		fmt.Fprintf(w, "<ele>%g</ele>", *ele)
+	}
+	_, err := fmt.Fprintf(w, "<time>%s</time><type>", p.LoggedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	if err := xml.EscapeText(w, []byte(p.LogType)); err != nil {
+		return err
+	}
+	_, err = w.WriteString("</type></trkpt>")
+	return err
+}
+
+func (g *gpxWriter) end(w *bufio.Writer) error {
+	_, err := w.WriteString("</trkseg></trk></gpx>")
+	return err
+}
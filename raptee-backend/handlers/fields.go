@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"raptee-backend/models"
+)
+
+// coerceField checks a payload value against its field definition and returns
+// it in canonical form: ints as int64, floats as float64. Numeric strings are
+// accepted for int/float and "true"/"false" for bool, since older firmware
+// stringifies values; anything else of the wrong type is an error.
+func coerceField(def models.FieldDef, v interface{}) (interface{}, error) {
+	if v == nil {
+		if def.Required {
+			return nil, fmt.Errorf("%s is required", def.Name)
+		}
+		return nil, nil
+	}
+
+	switch def.Type {
+	case models.FieldInt:
+		// MaxInt64 rounds up to 2^63 as a float64, so the upper bound is exclusive
+		f, ok := toFloat(v)
+		if !ok || math.IsNaN(f) || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return nil, fmt.Errorf("%s: expected int, got %s", def.Name, describe(v))
+		}
+		return int64(f), nil
+
+	case models.FieldFloat:
+		f, ok := toFloat(v)
+		if !ok || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%s: expected float, got %s", def.Name, describe(v))
+		}
+		return f, nil
+
+	case models.FieldString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected string, got %s", def.Name, describe(v))
+		}
+		return s, nil
+
+	case models.FieldBool:
+		switch val := v.(type) {
+		case bool:
+			return val, nil
+		case string:
+			if b, err := strconv.ParseBool(val); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: expected bool, got %s", def.Name, describe(v))
+
+	case models.FieldEnum:
+		s, ok := v.(string)
+		if ok {
+			for _, allowed := range def.Values {
+				if s == allowed {
+					return s, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("%s: expected one of [%s], got %s", def.Name, strings.Join(def.Values, ", "), describe(v))
+	}
+
+	// FieldAny
+	return v, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func describe(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return strconv.Quote(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// validateFieldDefs checks a schema's field definitions before they are stored
+func validateFieldDefs(defs []models.FieldDef) error {
+	if len(defs) == 0 {
+		return fmt.Errorf("fields must not be empty")
+	}
+	seen := make(map[string]bool, len(defs))
+	for _, d := range defs {
+		if d.Name == "" {
+			return fmt.Errorf("field names must not be empty")
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("duplicate field %q", d.Name)
+		}
+		seen[d.Name] = true
+
+		switch d.Type {
+		case models.FieldInt, models.FieldFloat, models.FieldString, models.FieldBool, models.FieldAny:
+			if len(d.Values) > 0 {
+				return fmt.Errorf("field %q: values are only allowed for enum fields", d.Name)
+			}
+		case models.FieldEnum:
+			if len(d.Values) == 0 {
+				return fmt.Errorf("field %q: enum needs at least one allowed value", d.Name)
+			}
+		default:
+			return fmt.Errorf("field %q: unknown type %q (expected int, float, string, bool, enum or any)", d.Name, d.Type)
+		}
+	}
+	return nil
+}
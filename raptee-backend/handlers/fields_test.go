@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"raptee-backend/models"
+)
+
+// Ints must fit int64: a float beyond it would wrap silently on conversion
+func TestCoerceFieldIntRange(t *testing.T) {
+	def := models.FieldDef{Name: "count", Type: models.FieldInt}
+	for _, v := range []interface{}{1e20, -1e20, math.Pow(2, 63), math.NaN(), math.Inf(1), 1.5, "NaN"} {
+		if got, err := coerceField(def, v); err == nil {
+			t.Errorf("%v: expected an error, got %v", v, got)
+		}
+	}
+	for _, v := range []interface{}{0.0, -42.0, "7", math.Pow(2, 62), float64(math.MinInt64)} {
+		if _, err := coerceField(def, v); err != nil {
+			t.Errorf("%v: %v", v, err)
+		}
+	}
+}
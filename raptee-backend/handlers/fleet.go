@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"raptee-backend/db"
+	"raptee-backend/sketch"
+)
+
+// Ranking size bounds for HandleGetFleetAnalytics
+const (
+	defaultFleetTop = 10
+	maxFleetTop     = 100
+)
+
+// FleetAnalyticsResponse is GET /api/v1/analytics/fleet: the per-bike
+// analytics aggregates computed across every selected bike, plus rankings of
+// the worst units.
+type FleetAnalyticsResponse struct {
+	Source           string            `json:"source"`     // "rollup_day", "rollup_hour" or "raw"
+	BikeCount        int               `json:"bike_count"` // Bikes with at least one matching call
+	From             string            `json:"from,omitempty"`
+	To               string            `json:"to,omitempty"`
+	RawFrom          string            `json:"raw_from,omitempty"` // Raw reads start here: rows before it were pruned
+	Summary          AnalyticsSummary  `json:"summary"`
+	APIStats         []APIStat         `json:"api_stats"`
+	Connectivity     ConnectivityStats `json:"connectivity_stats"`
+	WorstSuccessRate []BikeRanking     `json:"worst_success_rate"`
+	WorstP95Latency  []BikeRanking     `json:"worst_p95_latency"`
+}
+
+// BikeRanking is one bike's entry in the fleet rankings.
+// P95 is over successful calls, like APIStat.
+type BikeRanking struct {
+	BikeID      string  `json:"bike_id"`
+	TotalCalls  int     `json:"total_calls"`
+	SuccessRate float64 `json:"success_rate"`
+	P95         float64 `json:"p95"`
+}
+
+// fleetFilter selects bikes by id and/or registry metadata, and their
+// API_LATENCY calls by time range and apiCallFilter
+type fleetFilter struct {
+	BikeIDs  []string
+	Metadata map[string]string // Dotted metadata path (e.g. config.eco_mode) -> value
+	From     time.Time
+	To       time.Time
+	apiCallFilter
+}
+
+// parseFleetFilter reads bike_id (repeatable or comma separated), from/to,
+// the api filters, and metadata.<path>=<value> pairs. Metadata values are
+// compared as text, so metadata.fw_version=2.1.0 and metadata.config.eco_mode=true
+// both work.
+func parseFleetFilter(c *gin.Context) (fleetFilter, error) {
+	f := fleetFilter{Metadata: map[string]string{}, apiCallFilter: parseAPICallFilter(c)}
+
+	var err error
+	if f.From, err = parseTimeParam(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseTimeParam(c, "to"); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("from must be before to")
+	}
+
+	f.BikeIDs = queryList(c, "bike_id")
+
+	for key, values := range c.Request.URL.Query() {
+		path, ok := strings.CutPrefix(key, "metadata.")
+		if !ok {
+			continue
+		}
+		if path == "" || len(values) != 1 {
+			return f, fmt.Errorf("%s must be given exactly once", key)
+		}
+		f.Metadata[path] = values[0]
+	}
+
+	return f, nil
+}
+
+// where returns the SQL predicate over a telemetry table t (telemetry_logs, or
+// telemetry_rollups with timeCol "bucket") joined with bikes b
+func (f fleetFilter) where(timeCol string) (string, []interface{}) {
+	conds := []string{"t.log_type = 'API_LATENCY'"}
+	var args []interface{}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		conds = append(conds, fmt.Sprintf("t.%s >= $%d", timeCol, len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		conds = append(conds, fmt.Sprintf("t.%s < $%d", timeCol, len(args)))
+	}
+	if len(f.BikeIDs) > 0 {
+		args = append(args, f.BikeIDs)
+		conds = append(conds, fmt.Sprintf("t.bike_id = ANY($%d)", len(args)))
+	}
+	for path, value := range f.Metadata {
+		args = append(args, strings.Split(path, "."), value)
+		conds = append(conds, fmt.Sprintf("b.metadata #>> $%d = $%d", len(args)-1, len(args)))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// bikeTally is the per-bike state behind the rankings
+type bikeTally struct {
+	total, success int
+	latencies      *sketch.Histogram // Success latencies only
+}
+
+// HandleGetFleetAnalytics computes summary, API and connectivity stats across
+// the whole fleet (or the bikes selected by bike_id / metadata.*), and ranks
+// bikes by worst success rate and worst p95 latency.
+//
+// top limits the rankings (default 10, max 100); min_calls (default 1) leaves
+// bikes with too few calls out of them.
+func HandleGetFleetAnalytics(c *gin.Context) {
+	filter, err := parseFleetFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	top, err := intParam(c, "top", defaultFleetTop)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	top = min(top, maxFleetTop)
+	minCalls, err := intParam(c, "min_calls", 1)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := computeFleetAnalytics(c.Request.Context(), filter, top, minCalls)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// computeFleetAnalytics reads telemetry_rollups when rollupSource allows
+// (the window lines up with their buckets and there is no network_type
+// filter), and the retained raw telemetry_logs otherwise.
+func computeFleetAnalytics(ctx context.Context, f fleetFilter, top, minCalls int) (FleetAnalyticsResponse, error) {
+	acc := newAnalyticsAccumulator(false)
+	tallies := map[string]*bikeTally{}
+	tally := func(bikeID string) *bikeTally {
+		t := tallies[bikeID]
+		if t == nil {
+			t = &bikeTally{latencies: sketch.New()}
+			tallies[bikeID] = t
+		}
+		return t
+	}
+
+	source := "raw"
+	var rawFrom string
+	var err error
+	if resolution, ok := rollupSource(f.From, f.To, f.NetworkType); ok {
+		source = "rollup_" + resolution
+		err = scanFleetRollups(ctx, f, resolution, func(r rollupRow) {
+			acc.addRollup(r)
+			t := tally(r.BikeID)
+			t.total += r.Total
+			t.success += r.Success
+			t.latencies.Merge(r.Latencies)
+		})
+	} else {
+		raw := f
+		var clamped bool
+		if raw.From, clamped, err = retainedFrom(ctx, f.From); err != nil {
+			return FleetAnalyticsResponse{}, err
+		}
+		if clamped {
+			rawFrom = formatBound(raw.From)
+		}
+		err = scanFleetRows(ctx, raw, func(bikeID string, loggedAt time.Time, latency int, call apiCall) {
+			t := tally(bikeID)
+			t.total++
+			if acc.add(loggedAt, latency, call) {
+				t.success++
+				t.latencies.Add(latency)
+			}
+		})
+	}
+	if err != nil {
+		return FleetAnalyticsResponse{}, err
+	}
+
+	fleet := acc.result()
+	resp := FleetAnalyticsResponse{
+		Source:       source,
+		BikeCount:    len(tallies),
+		From:         formatBound(f.From),
+		To:           formatBound(f.To),
+		RawFrom:      rawFrom,
+		Summary:      fleet.Summary,
+		APIStats:     fleet.APIStats,
+		Connectivity: fleet.Connectivity,
+	}
+
+	var ranked []BikeRanking
+	for id, t := range tallies {
+		if t.total < minCalls {
+			continue
+		}
+		ranked = append(ranked, BikeRanking{
+			BikeID:      id,
+			TotalCalls:  t.total,
+			SuccessRate: float64(t.success) / float64(t.total) * 100,
+			P95:         t.latencies.Quantile(0.95),
+		})
+	}
+
+	resp.WorstSuccessRate = rankBikes(ranked, top, func(a, b BikeRanking) bool { return a.SuccessRate < b.SuccessRate })
+	resp.WorstP95Latency = rankBikes(ranked, top, func(a, b BikeRanking) bool { return a.P95 > b.P95 })
+	return resp, nil
+}
+
+// scanFleetRows feeds every matching raw API_LATENCY call to fn
+func scanFleetRows(ctx context.Context, f fleetFilter, fn func(bikeID string, loggedAt time.Time, latency int, call apiCall)) error {
+	where, args := f.where("logged_at")
+	sql := `SELECT t.bike_id, t.logged_at, t.val_primary, t.payload
+			FROM telemetry_logs t JOIN bikes b ON b.bike_id = t.bike_id
+			WHERE ` + where
+
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bikeID string
+		var loggedAt time.Time
+		var latency int
+		var payloadBytes []byte
+
+		if err := rows.Scan(&bikeID, &loggedAt, &latency, &payloadBytes); err != nil {
+			continue
+		}
+		call, ok := parseAPICall(bikeID, payloadBytes)
+		if !ok || !f.matches(call) {
+			continue
+		}
+		fn(bikeID, loggedAt, latency, call)
+	}
+	return rows.Err()
+}
+
+// scanFleetRollups feeds every matching rollup row of the given resolution to fn
+func scanFleetRollups(ctx context.Context, f fleetFilter, resolution string, fn func(rollupRow)) error {
+	where, args := f.where("bucket")
+	args = append(args, resolution)
+	where += fmt.Sprintf(" AND t.resolution = $%d", len(args))
+	if f.APIName != "" {
+		args = append(args, f.APIName)
+		where += fmt.Sprintf(" AND t.api_call = $%d", len(args))
+	}
+	if f.ConnectionState != "" {
+		args = append(args, f.ConnectionState)
+		where += fmt.Sprintf(" AND t.connection_state = $%d", len(args))
+	}
+
+	sql := `SELECT t.bike_id, t.api_call, t.connection_state, t.total_calls, t.success_calls,
+				t.network_errors, t.server_errors, t.client_errors, t.first_at, t.last_at, t.latency_sketch
+			FROM telemetry_rollups t JOIN bikes b ON b.bike_id = t.bike_id
+			WHERE ` + where + ` AND t.total_calls > 0`
+
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := rollupRow{Latencies: sketch.New()}
+		var stored []byte
+		err := rows.Scan(&r.BikeID, &r.APICall, &r.ConnectionState, &r.Total, &r.Success,
+			&r.NetworkErrors, &r.ServerErrors, &r.ClientErrors, &r.FirstAt, &r.LastAt, &stored)
+		if err != nil {
+			return err
+		}
+		if len(stored) > 0 {
+			if err := r.Latencies.UnmarshalBinary(stored); err != nil {
+				return err
+			}
+		}
+		fn(r)
+	}
+	return rows.Err()
+}
+
+// rankBikes returns the first n bikes ordered by worse (ties by bike_id)
+func rankBikes(bikes []BikeRanking, n int, worse func(a, b BikeRanking) bool) []BikeRanking {
+	out := append([]BikeRanking{}, bikes...)
+	sort.Slice(out, func(i, j int) bool {
+		if worse(out[i], out[j]) {
+			return true
+		}
+		if worse(out[j], out[i]) {
+			return false
+		}
+		return out[i].BikeID < out[j].BikeID
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// intParam reads a non-negative integer query parameter
+func intParam(c *gin.Context, name string, def int) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
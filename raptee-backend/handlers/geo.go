@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"raptee-backend/db"
+)
+
+// Heatmap grid bounds: cells are 1/heatmapCellsPerTile of a map tile's width
+// at the requested zoom, and one request may cover at most maxHeatmapCells.
+const (
+	heatmapCellsPerTile = 16
+	maxHeatmapZoom      = 20
+	maxHeatmapCells     = 20000
+)
+
+// HeatmapResponse is GET /api/v1/geo/heatmap
+type HeatmapResponse struct {
+	Zoom     int           `json:"zoom"`
+	CellSize float64       `json:"cell_size"` // Cell width and height in degrees
+	BBox     [4]float64    `json:"bbox"`      // min_lng, min_lat, max_lng, max_lat
+	From     string        `json:"from,omitempty"`
+	To       string        `json:"to,omitempty"`
+	Cells    []HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell aggregates the points in one grid cell. FailureRate is over
+// the cell's API_LATENCY rows (status_code other than 200, as in analytics)
+// and is null when it has none.
+type HeatmapCell struct {
+	Lat            float64  `json:"lat"` // Cell center
+	Lng            float64  `json:"lng"`
+	Count          int      `json:"count"`
+	APICalls       int      `json:"api_calls"`
+	FailureRate    *float64 `json:"failure_rate"`
+	MeanValPrimary float64  `json:"mean_val_primary"`
+}
+
+// geoFilter selects located telemetry across bikes by time range and log type
+type geoFilter struct {
+	BikeIDs        []string // Empty = every bike
+	From           time.Time
+	To             time.Time
+	Types          []string // Empty = every log type
+	IncludeFlagged bool     // Also fixes the GPS check flagged
+}
+
+// parseGeoFilter reads bike_id and type (both optional, repeatable or comma
+// separated), from/to, and include_flagged (default false)
+func parseGeoFilter(c *gin.Context) (geoFilter, error) {
+	f := geoFilter{BikeIDs: queryList(c, "bike_id"), Types: queryList(c, "type")}
+
+	var err error
+	if v := c.Query("include_flagged"); v != "" {
+		if f.IncludeFlagged, err = strconv.ParseBool(v); err != nil {
+			return f, fmt.Errorf("include_flagged must be true or false")
+		}
+	}
+	if f.From, err = parseTimeParam(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseTimeParam(c, "to"); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("from must be before to")
+	}
+	return f, nil
+}
+
+// where returns the SQL predicate over telemetry_logs for the filter, with
+// its arguments numbered from $1
+func (f geoFilter) where() (string, []interface{}) {
+	conds := []string{"location IS NOT NULL"}
+	var args []interface{}
+
+	if !f.IncludeFlagged {
+		conds = append(conds, "(gps_flag IS NULL OR gps_flag = 'ok')")
+	}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		conds = append(conds, fmt.Sprintf("logged_at >= $%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		conds = append(conds, fmt.Sprintf("logged_at < $%d", len(args)))
+	}
+	if len(f.BikeIDs) > 0 {
+		args = append(args, f.BikeIDs)
+		conds = append(conds, fmt.Sprintf("bike_id = ANY($%d)", len(args)))
+	}
+	if len(f.Types) > 0 {
+		args = append(args, f.Types)
+		conds = append(conds, fmt.Sprintf("log_type = ANY($%d)", len(args)))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// parseBBox reads bbox=min_lng,min_lat,max_lng,max_lat (WGS84 degrees).
+// Boxes crossing the antimeridian are not supported; split them in two.
+func parseBBox(v string) ([4]float64, error) {
+	var box [4]float64
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return box, fmt.Errorf("bbox must be min_lng,min_lat,max_lng,max_lat")
+	}
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) {
+			return box, fmt.Errorf("bbox must be min_lng,min_lat,max_lng,max_lat")
+		}
+		box[i] = f
+	}
+	if box[0] < -180 || box[2] > 180 || box[1] < -90 || box[3] > 90 {
+		return box, fmt.Errorf("bbox is outside -180..180 / -90..90")
+	}
+	if box[0] >= box[2] || box[1] >= box[3] {
+		return box, fmt.Errorf("bbox min must be below max")
+	}
+	return box, nil
+}
+
+// HandleGeoHeatmap aggregates located telemetry inside bbox into a square
+// lng/lat grid sized for the map zoom, returning the count, API failure rate
+// and mean val_primary of every non-empty cell.
+//
+// Accepts bbox and zoom (0-20, both required) plus the bike_id, type and
+// from/to filters of parseGeoFilter.
+func HandleGeoHeatmap(c *gin.Context) {
+	filter, err := parseGeoFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	box, err := parseBBox(c.Query("bbox"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	zoom, err := strconv.Atoi(c.Query("zoom"))
+	if err != nil || zoom < 0 || zoom > maxHeatmapZoom {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("zoom must be an integer from 0 to %d", maxHeatmapZoom)})
+		return
+	}
+
+	size := 360 / math.Exp2(float64(zoom)) / heatmapCellsPerTile
+	cols := math.Ceil((box[2]-box[0])/size) + 1
+	rows := math.Ceil((box[3]-box[1])/size) + 1
+	if cols*rows > maxHeatmapCells {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("bbox spans more than %d cells at zoom %d; use a lower zoom or a smaller bbox", maxHeatmapCells, zoom)})
+		return
+	}
+
+	where, args := filter.where()
+	args = append(args, box[0], box[1], box[2], box[3], size)
+	n := len(args)
+	sql := fmt.Sprintf(`
+	SELECT floor(ST_X(g) / $%[6]d)::bigint AS cx, floor(ST_Y(g) / $%[6]d)::bigint AS cy,
+		COUNT(*),
+		COUNT(*) FILTER (WHERE log_type = 'API_LATENCY'),
+		COUNT(*) FILTER (WHERE log_type = 'API_LATENCY'
+			AND COALESCE(payload->>'status_code', payload->>2) IS DISTINCT FROM '200'),
+		COALESCE(AVG(val_primary), 0)::float8
+	FROM (
+		SELECT location::geometry AS g, log_type, val_primary, payload
+		FROM telemetry_logs
+		WHERE %[1]s AND location::geometry && ST_MakeEnvelope($%[2]d, $%[3]d, $%[4]d, $%[5]d, 4326)
+	) pts
+	GROUP BY 1, 2
+	ORDER BY 2, 1`, where, n-4, n-3, n-2, n-1, n)
+
+	dbRows, err := db.Pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer dbRows.Close()
+
+	cells := []HeatmapCell{}
+	for dbRows.Next() {
+		var cx, cy int64
+		var failed int
+		var cell HeatmapCell
+		if err := dbRows.Scan(&cx, &cy, &cell.Count, &cell.APICalls, &failed, &cell.MeanValPrimary); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		cell.Lng = (float64(cx) + 0.5) * size
+		cell.Lat = (float64(cy) + 0.5) * size
+		if cell.APICalls > 0 {
+			rate := float64(failed) / float64(cell.APICalls) * 100
+			cell.FailureRate = &rate
+		}
+		cells = append(cells, cell)
+	}
+	if err := dbRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, HeatmapResponse{
+		Zoom:     zoom,
+		CellSize: size,
+		BBox:     box,
+		From:     formatBound(filter.From),
+		To:       formatBound(filter.To),
+		Cells:    cells,
+	})
+}
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"raptee-backend/db"
+	"raptee-backend/models"
+)
+
+// --- GEOFENCES ---
+// Geofences are named polygons. Sync detects when consecutive located rows of
+// a bike are on different sides of a fence boundary and records an enter or
+// exit event at the first row on the new side. Like trips, detection is redone
+// around every synced batch, so late uploads slot into the event history.
+
+const (
+	defaultGeofenceEventLimit = 100
+	maxGeofenceEventLimit     = 1000
+)
+
+const geofenceColumns = "fence_id, name, COALESCE(kind, ''), ST_AsGeoJSON(geom), created_at, updated_at"
+
+func scanGeofence(row pgx.Row) (models.Geofence, error) {
+	var f models.Geofence
+	var geometry string
+	err := row.Scan(&f.FenceID, &f.Name, &f.Kind, &geometry, &f.CreatedAt, &f.UpdatedAt)
+	f.Geometry = json.RawMessage(geometry)
+	return f, err
+}
+
+// validateGeofence checks the name and that geometry is a valid GeoJSON
+// Polygon or MultiPolygon
+func validateGeofence(ctx context.Context, f models.Geofence) error {
+	if strings.TrimSpace(f.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	var shape struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(f.Geometry, &shape); err != nil || (shape.Type != "Polygon" && shape.Type != "MultiPolygon") {
+		return fmt.Errorf("geometry must be a GeoJSON Polygon or MultiPolygon")
+	}
+
+	var reason string
+	err := db.Pool.QueryRow(ctx, "SELECT ST_IsValidReason(ST_GeomFromGeoJSON($1))", string(f.Geometry)).Scan(&reason)
+	if err != nil {
+		return fmt.Errorf("geometry is not valid GeoJSON: %v", err)
+	}
+	if reason != "Valid Geometry" {
+		return fmt.Errorf("geometry is invalid: %s", reason)
+	}
+	return nil
+}
+
+// HandleCreateGeofence registers a named polygon
+func HandleCreateGeofence(c *gin.Context) {
+	var req models.Geofence
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+		return
+	}
+	if err := validateGeofence(c.Request.Context(), req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fence, err := scanGeofence(db.Pool.QueryRow(context.Background(), `
+	INSERT INTO geofences (name, kind, geom)
+	VALUES ($1, NULLIF($2, ''), ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($3), 4326)))
+	RETURNING `+geofenceColumns, req.Name, req.Kind, string(req.Geometry)))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Geofence name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, fence)
+}
+
+// HandleUpdateGeofence replaces a fence's name, kind and geometry. Events
+// already recorded are kept; new syncs are checked against the new shape.
+func HandleUpdateGeofence(c *gin.Context) {
+	fenceID, err := strconv.ParseInt(c.Param("fence_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fence_id must be an integer"})
+		return
+	}
+	var req models.Geofence
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+		return
+	}
+	if err := validateGeofence(c.Request.Context(), req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fence, err := scanGeofence(db.Pool.QueryRow(context.Background(), `
+	UPDATE geofences SET name = $2, kind = NULLIF($3, ''),
+		geom = ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($4), 4326)), updated_at = NOW()
+	WHERE fence_id = $1
+	RETURNING `+geofenceColumns, fenceID, req.Name, req.Kind, string(req.Geometry)))
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		c.JSON(http.StatusConflict, gin.H{"error": "Geofence name already exists"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+	default:
+		c.JSON(http.StatusOK, fence)
+	}
+}
+
+// HandleDeleteGeofence removes a fence and its events
+func HandleDeleteGeofence(c *gin.Context) {
+	fenceID, err := strconv.ParseInt(c.Param("fence_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fence_id must be an integer"})
+		return
+	}
+
+	res, err := db.Pool.Exec(context.Background(), "DELETE FROM geofences WHERE fence_id = $1", fenceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted", "fence_id": fenceID})
+}
+
+// HandleListGeofences returns every fence, by name
+func HandleListGeofences(c *gin.Context) {
+	rows, err := db.Pool.Query(context.Background(), "SELECT "+geofenceColumns+" FROM geofences ORDER BY name")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	fences := []models.Geofence{}
+	for rows.Next() {
+		f, err := scanGeofence(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		fences = append(fences, f)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fences)
+}
+
+// HandleGetGeofence returns one fence
+func HandleGetGeofence(c *gin.Context) {
+	fenceID, err := strconv.ParseInt(c.Param("fence_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fence_id must be an integer"})
+		return
+	}
+
+	fence, err := scanGeofence(db.Pool.QueryRow(context.Background(), "SELECT "+geofenceColumns+" FROM geofences WHERE fence_id = $1", fenceID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fence)
+}
+
+// HandleListFenceEvents lists the enter/exit events of one fence, newest
+// first. Optional bike_id, from/to and limit (default 100, max 1000).
+func HandleListFenceEvents(c *gin.Context) {
+	fenceID, err := strconv.ParseInt(c.Param("fence_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fence_id must be an integer"})
+		return
+	}
+	conds := []string{"e.fence_id = $1"}
+	args := []interface{}{fenceID}
+	if bikeID := c.Query("bike_id"); bikeID != "" {
+		args = append(args, bikeID)
+		conds = append(conds, fmt.Sprintf("e.bike_id = $%d", len(args)))
+	}
+	listGeofenceEvents(c, conds, args)
+}
+
+// HandleListBikeGeofenceEvents lists the enter/exit events of one bike,
+// newest first. Optional fence_id, from/to and limit (default 100, max 1000).
+func HandleListBikeGeofenceEvents(c *gin.Context) {
+	conds := []string{"e.bike_id = $1"}
+	args := []interface{}{c.Param("bike_id")}
+	if v := c.Query("fence_id"); v != "" {
+		fenceID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fence_id must be an integer"})
+			return
+		}
+		args = append(args, fenceID)
+		conds = append(conds, fmt.Sprintf("e.fence_id = $%d", len(args)))
+	}
+	listGeofenceEvents(c, conds, args)
+}
+
+// listGeofenceEvents adds the from/to and limit parameters to conds and
+// writes the matching events
+func listGeofenceEvents(c *gin.Context, conds []string, args []interface{}) {
+	for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+		t, err := parseTimeParam(c, bound.param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !t.IsZero() {
+			args = append(args, t)
+			conds = append(conds, fmt.Sprintf("e.occurred_at %s $%d", bound.op, len(args)))
+		}
+	}
+
+	limit := defaultGeofenceEventLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(l, maxGeofenceEventLimit)
+	}
+	args = append(args, limit)
+
+	sql := fmt.Sprintf(`
+	SELECT e.event_id, e.fence_id, f.name, e.bike_id, e.event, e.occurred_at, e.log_id::text,
+		ST_Y(e.location::geometry), ST_X(e.location::geometry)
+	FROM geofence_events e JOIN geofences f ON f.fence_id = e.fence_id
+	WHERE %s
+	ORDER BY e.occurred_at DESC, e.event_id DESC
+	LIMIT $%d`, strings.Join(conds, " AND "), len(args))
+
+	rows, err := db.Pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	events := []models.GeofenceEvent{}
+	for rows.Next() {
+		var e models.GeofenceEvent
+		if err := rows.Scan(&e.EventID, &e.FenceID, &e.FenceName, &e.BikeID, &e.Event, &e.OccurredAt, &e.LogID, &e.Lat, &e.Lng); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// fencedPoint is a located row and the fences containing it
+type fencedPoint struct {
+	LogID    string
+	At       time.Time
+	Lat, Lng float64
+	Fences   []int64 // Sorted
+}
+
+// detectGeofenceEvents redoes a bike's geofence events for the located rows
+// in [from, to] inside tx. The last located row before the window is the
+// starting side; the first one after it is re-checked since its predecessor
+// may have changed. Fixes flagged by the GPS check are ignored.
+func detectGeofenceEvents(ctx context.Context, tx pgx.Tx, bikeID string, from, to time.Time) error {
+	var anyFences bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM geofences)").Scan(&anyFences); err != nil || !anyFences {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('geofences:' || $1))", bikeID); err != nil {
+		return err
+	}
+
+	var before, after *time.Time
+	err := tx.QueryRow(ctx, `
+	SELECT (SELECT MAX(logged_at) FROM telemetry_logs WHERE bike_id = $1 AND location IS NOT NULL
+			AND (gps_flag IS NULL OR gps_flag = 'ok') AND logged_at < $2),
+		(SELECT MIN(logged_at) FROM telemetry_logs WHERE bike_id = $1 AND location IS NOT NULL
+			AND (gps_flag IS NULL OR gps_flag = 'ok') AND logged_at > $3)`,
+		bikeID, from, to).Scan(&before, &after)
+	if err != nil {
+		return err
+	}
+	lo, hi := from, to
+	if before != nil {
+		lo = *before
+	}
+	if after != nil {
+		hi = *after
+	}
+
+	// Events at the anchor row belong to the window before it
+	deleteSQL := "DELETE FROM geofence_events WHERE bike_id = $1 AND occurred_at >= $2 AND occurred_at <= $3"
+	if before != nil {
+		deleteSQL = "DELETE FROM geofence_events WHERE bike_id = $1 AND occurred_at > $2 AND occurred_at <= $3"
+	}
+	if _, err := tx.Exec(ctx, deleteSQL, bikeID, lo, hi); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+	SELECT t.log_id::text, t.logged_at, ST_Y(p.g), ST_X(p.g),
+		ARRAY(SELECT f.fence_id FROM geofences f WHERE ST_Intersects(f.geom, p.g) ORDER BY f.fence_id)
+	FROM telemetry_logs t, LATERAL (SELECT t.location::geometry AS g) p
+	WHERE t.bike_id = $1 AND t.location IS NOT NULL AND (t.gps_flag IS NULL OR t.gps_flag = 'ok') AND t.logged_at >= $2 AND t.logged_at <= $3
+	ORDER BY t.logged_at, t.log_id`, bikeID, lo, hi)
+	if err != nil {
+		return err
+	}
+	var points []fencedPoint
+	for rows.Next() {
+		var p fencedPoint
+		if err := rows.Scan(&p.LogID, &p.At, &p.Lat, &p.Lng, &p.Fences); err != nil {
+			rows.Close()
+			return err
+		}
+		points = append(points, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var fenceIDs []int64
+	var kinds, logIDs []string
+	var times []time.Time
+	var lats, lngs []float64
+	record := func(fenceID int64, kind string, p fencedPoint) {
+		fenceIDs = append(fenceIDs, fenceID)
+		kinds = append(kinds, kind)
+		logIDs = append(logIDs, p.LogID)
+		times = append(times, p.At)
+		lats = append(lats, p.Lat)
+		lngs = append(lngs, p.Lng)
+	}
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		for _, id := range cur.Fences {
+			if !containsFence(prev.Fences, id) {
+				record(id, "enter", cur)
+			}
+		}
+		for _, id := range prev.Fences {
+			if !containsFence(cur.Fences, id) {
+				record(id, "exit", cur)
+			}
+		}
+	}
+	if len(fenceIDs) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO geofence_events (fence_id, bike_id, event, occurred_at, log_id, location)
+	SELECT u.fence_id, $1, u.event, u.occurred_at, u.log_id::uuid, ST_SetSRID(ST_MakePoint(u.lng, u.lat), 4326)::geography
+	FROM unnest($2::bigint[], $3::text[], $4::timestamptz[], $5::text[], $6::float8[], $7::float8[])
+		AS u(fence_id, event, occurred_at, log_id, lat, lng)`,
+		bikeID, fenceIDs, kinds, times, logIDs, lats, lngs)
+	return err
+}
+
+func containsFence(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/db"
+)
+
+// --- GPS QUALITY ---
+// Sync checks every located row against the bike's last good fix and stores
+// the verdict in telemetry_logs.gps_flag. Jumps and out-of-region fixes also
+// get a synthesized GPS_ANOMALY row (payload "source": "server") next to the
+// ones firmware reports. Trips, geofences and the map endpoints skip flagged
+// rows. Like trips, the check is redone around every synced batch.
+
+// gps_flag values
+const (
+	gpsOK          = "ok"
+	gpsNullIsland  = "null_island"
+	gpsOutOfRegion = "out_of_region"
+	gpsJump        = "jump"
+)
+
+// gpsRegion is the operating region (min_lng, min_lat, max_lng, max_lat) set
+// by GPS_REGION_BBOX. While it is nil no fix is flagged out of region.
+var gpsRegion *[4]float64
+
+// gpsAnomalyNamespace derives a synthesized GPS_ANOMALY row's log_id from the
+// flagged row's, so re-checking a window replaces events instead of adding more
+var gpsAnomalyNamespace = uuid.MustParse("3d6f1c2a-7b1e-4f0a-b8c4-5e2d9a4f6c31")
+
+// LoadGPSRegion reads GPS_REGION_BBOX (min_lng,min_lat,max_lng,max_lat).
+// When it is unset the region check is disabled, rather than assuming one.
+func LoadGPSRegion() error {
+	v := os.Getenv("GPS_REGION_BBOX")
+	if v == "" {
+		gpsRegion = nil
+		log.Println("GPS_REGION_BBOX not set; out-of-region GPS checks are disabled")
+		return nil
+	}
+	box, err := parseBBox(v)
+	if err != nil {
+		return fmt.Errorf("GPS_REGION_BBOX: %v", err)
+	}
+	gpsRegion = &box
+	return nil
+}
+
+// gpsFix is one located row being checked
+type gpsFix struct {
+	LogID    string
+	At       time.Time
+	Lat, Lng float64
+	Flag     string
+	Jump     float64 // Meters from the last good fix (jumps only)
+	Speed    float64 // Implied m/s from the last good fix (jumps only)
+}
+
+func (f gpsFix) point() tripPoint {
+	return tripPoint{At: f.At, Lat: f.Lat, Lng: f.Lng}
+}
+
+// classifyFixes flags time-ordered fixes. prev is the last good fix before
+// them, if any; region is nil to skip the region check. A fix reached faster than tripMaxSpeed from prev is a jump,
+// unless it is a plausible move from the jump just before it: then the bike
+// really moved (e.g. it was carried while off) and it becomes the new good
+// fix, so one bad fix can't condemn everything after it.
+func classifyFixes(prev *tripPoint, fixes []gpsFix, region *[4]float64) {
+	var lastJump *tripPoint
+	for i := range fixes {
+		f := &fixes[i]
+		p := f.point()
+		switch {
+		case f.Lat == 0 && f.Lng == 0:
+			f.Flag = gpsNullIsland
+			continue
+		case region != nil && (f.Lng < region[0] || f.Lat < region[1] || f.Lng > region[2] || f.Lat > region[3]):
+			f.Flag = gpsOutOfRegion
+			continue
+		}
+
+		if prev != nil && !reachable(*prev, p) && (lastJump == nil || !reachable(*lastJump, p)) {
+			f.Flag, f.Jump = gpsJump, distance(*prev, p)
+			f.Speed = f.Jump / math.Max(p.At.Sub(prev.At).Seconds(), 1)
+			lastJump = &p
+			continue
+		}
+		f.Flag = gpsOK
+		prev, lastJump = &p, nil
+	}
+}
+
+// reachable reports whether a bike could get from a to b without exceeding
+// tripMaxSpeed
+func reachable(a, b tripPoint) bool {
+	return distance(a, b) <= tripMaxSpeed*math.Max(b.At.Sub(a.At).Seconds(), 1)
+}
+
+// anomalyPayload is the GPS_ANOMALY payload synthesized for a flagged fix.
+// anomaly/description/jump_distance are the v1 schema fields firmware sends.
+func (f gpsFix) anomalyPayload() map[string]interface{} {
+	p := map[string]interface{}{
+		"source":        "server",
+		"source_log_id": f.LogID,
+		"lat":           f.Lat,
+		"lng":           f.Lng,
+		"jump_distance": f.Jump,
+	}
+	if f.Flag == gpsJump {
+		p["anomaly"] = "Jump Anomaly"
+		p["description"] = fmt.Sprintf("Jump of %.0fm at %.0f km/h", f.Jump, f.Speed*3.6)
+		p["implied_speed_kmh"] = math.Round(f.Speed * 3.6)
+	} else {
+		p["anomaly"] = "Out Of Region"
+		p["description"] = fmt.Sprintf("Fix at %.5f,%.5f is outside the operating region", f.Lat, f.Lng)
+	}
+	return p
+}
+
+// checkGPS re-checks a bike's located rows in [from, to] inside tx. The last
+// good fix before the window is the starting point; the first located row
+// after it is re-checked since its predecessor may have changed.
+func checkGPS(ctx context.Context, tx pgx.Tx, bikeID string, from, to time.Time) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('gps:' || $1))", bikeID); err != nil {
+		return err
+	}
+
+	var prev *tripPoint
+	var p tripPoint
+	err := tx.QueryRow(ctx, `
+	SELECT logged_at, ST_Y(location::geometry), ST_X(location::geometry)
+	FROM telemetry_logs
+	WHERE bike_id = $1 AND gps_flag = 'ok' AND logged_at < $2
+	ORDER BY logged_at DESC, log_id DESC LIMIT 1`, bikeID, from).Scan(&p.At, &p.Lat, &p.Lng)
+	switch {
+	case err == nil:
+		prev = &p
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	var after *time.Time
+	err = tx.QueryRow(ctx, "SELECT MIN(logged_at) FROM telemetry_logs WHERE bike_id = $1 AND location IS NOT NULL AND logged_at > $2",
+		bikeID, to).Scan(&after)
+	if err != nil {
+		return err
+	}
+	if after != nil {
+		to = *after
+	}
+
+	_, err = flagFixes(ctx, tx, bikeID, prev, from, to)
+	return err
+}
+
+// flagFixes classifies the bike's located rows in [lo, hi] (zero = unbounded)
+// after prev, stores their flags and replaces the synthesized GPS_ANOMALY
+// rows of the range. It returns how many fixes were flagged.
+func flagFixes(ctx context.Context, tx pgx.Tx, bikeID string, prev *tripPoint, lo, hi time.Time) (int, error) {
+	bounds := ""
+	args := []interface{}{bikeID}
+	if !lo.IsZero() {
+		args = append(args, lo)
+		bounds += fmt.Sprintf(" AND logged_at >= $%d", len(args))
+	}
+	if !hi.IsZero() {
+		args = append(args, hi)
+		bounds += fmt.Sprintf(" AND logged_at <= $%d", len(args))
+	}
+
+	rows, err := tx.Query(ctx, `
+	SELECT log_id::text, logged_at, ST_Y(location::geometry), ST_X(location::geometry)
+	FROM telemetry_logs
+	WHERE bike_id = $1 AND location IS NOT NULL`+bounds+`
+	ORDER BY logged_at, log_id`, args...)
+	if err != nil {
+		return 0, err
+	}
+	var fixes []gpsFix
+	for rows.Next() {
+		var f gpsFix
+		if err := rows.Scan(&f.LogID, &f.At, &f.Lat, &f.Lng); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		fixes = append(fixes, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	classifyFixes(prev, fixes, gpsRegion)
+
+	ids := make([]string, len(fixes))
+	times := make([]time.Time, len(fixes))
+	flags := make([]string, len(fixes))
+	var eventIDs []uuid.UUID
+	var eventTimes []time.Time
+	var eventVals []int32
+	var eventPayloads [][]byte
+	for i, f := range fixes {
+		ids[i], times[i], flags[i] = f.LogID, f.At, f.Flag
+		if f.Flag != gpsJump && f.Flag != gpsOutOfRegion {
+			continue
+		}
+		payload, err := json.Marshal(f.anomalyPayload())
+		if err != nil {
+			return 0, err
+		}
+		eventIDs = append(eventIDs, uuid.NewSHA1(gpsAnomalyNamespace, []byte(f.LogID)))
+		eventTimes = append(eventTimes, f.At)
+		eventVals = append(eventVals, int32(math.Round(f.Jump)))
+		eventPayloads = append(eventPayloads, payload)
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE telemetry_logs t SET gps_flag = u.flag
+	FROM unnest($2::text[], $3::timestamptz[], $4::text[]) AS u(log_id, logged_at, flag)
+	WHERE t.bike_id = $1 AND t.log_id = u.log_id::uuid AND t.logged_at = u.logged_at
+		AND t.gps_flag IS DISTINCT FROM u.flag`, bikeID, ids, times, flags)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM telemetry_logs
+	WHERE bike_id = $1 AND log_type = 'GPS_ANOMALY' AND payload->>'source' = 'server'`+bounds, args...)
+	if err != nil {
+		return 0, err
+	}
+	if len(eventIDs) > 0 {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO telemetry_logs (log_id, bike_id, logged_at, log_type, schema_version, val_primary, payload)
+		SELECT u.log_id, $1, u.logged_at, 'GPS_ANOMALY', 1, u.val_primary, u.payload
+		FROM unnest($2::uuid[], $3::timestamptz[], $4::int[], $5::jsonb[]) AS u(log_id, logged_at, val_primary, payload)
+		ON CONFLICT (bike_id, logged_at, log_id) DO NOTHING`, bikeID, eventIDs, eventTimes, eventVals, eventPayloads)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	flagged := 0
+	for _, f := range flags {
+		if f != gpsOK {
+			flagged++
+		}
+	}
+	return flagged, nil
+}
+
+// RebuildGPSFlags re-checks all of a bike's located telemetry, e.g. for rows
+// stored before the check existed or after changing GPS_REGION_BBOX (see
+// cmd/gps-quality). Rebuild trips afterwards so they follow the new flags.
+func RebuildGPSFlags(ctx context.Context, bikeID string) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('gps:' || $1))", bikeID); err != nil {
+		return 0, err
+	}
+	n, err := flagFixes(ctx, tx, bikeID, nil, time.Time{}, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// Without GPS_REGION_BBOX no fix is out of region; a far fix can still be a jump
+func TestClassifyFixesRegion(t *testing.T) {
+	start := time.Date(2025, 11, 28, 9, 0, 0, 0, time.UTC)
+	fixes := func() []gpsFix {
+		var out []gpsFix
+		for i, p := range [][2]float64{{13.000, 80.25}, {13.001, 80.25}, {51.5, -0.12}} {
+			out = append(out, gpsFix{At: start.Add(time.Duration(i) * 30 * time.Second), Lat: p[0], Lng: p[1]})
+		}
+		return out
+	}
+
+	india := [4]float64{68, 6, 98, 38}
+	for _, tc := range []struct {
+		name   string
+		region *[4]float64
+		want   string
+	}{
+		{"region set", &india, gpsOutOfRegion},
+		{"region unset", nil, gpsJump},
+	} {
+		f := fixes()
+		classifyFixes(nil, f, tc.region)
+		if f[0].Flag != gpsOK || f[1].Flag != gpsOK || f[2].Flag != tc.want {
+			t.Errorf("%s: got flags %s/%s/%s, want ok/ok/%s", tc.name, f[0].Flag, f[1].Flag, f[2].Flag, tc.want)
+		}
+	}
+}
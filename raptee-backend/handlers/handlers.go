@@ -6,10 +6,12 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"raptee-backend/db"
+	"raptee-backend/middleware"
 	"raptee-backend/models"
 	"raptee-backend/utils"
 )
@@ -17,6 +19,13 @@ import (
 
 // --- PROVISION HANDLER ---
 
+// HandleProvision registers a bike or updates its metadata.
+//
+// First-time provisioning takes an operator API key instead of a signature and
+// is only accepted while the bike has no credentials yet; it mints a device
+// secret that is returned once in the response. After that, updates must be
+// signed by the bike (see middleware.DeviceAuthOrRole), and may ask for a
+// fresh secret with rotate_secret.
 func HandleProvision(c *gin.Context) {
 	var req models.ProvisionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -29,26 +38,74 @@ func HandleProvision(c *gin.Context) {
 		return
 	}
 
-	// Upsert Bike Metadata
-	sql := `
-	INSERT INTO bikes (bike_id, metadata, last_seen_at)
-	VALUES ($1, $2, NOW())
-	ON CONFLICT (bike_id)
-	DO UPDATE SET metadata = $2, last_seen_at = NOW()`
+	authedBike := c.GetString(middleware.DeviceBikeIDKey)
+	if authedBike != "" && authedBike != req.BikeID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "bike_id does not match the signing bike"})
+		return
+	}
+	signed := authedBike != ""
 
 	// Handle nil maps gracefully
 	if req.Metadata == nil {
 		req.Metadata = make(map[string]interface{})
 	}
 
-	_, err := db.Pool.Exec(context.Background(), sql, req.BikeID, req.Metadata)
+	// Mint a new secret for first-time provisioning or an explicit rotation.
+	// Only its derived key is stored, sealed with the server key.
+	var secret *string
+	var sealedKey []byte
+	if !signed || req.RotateSecret {
+		s, err := utils.NewDeviceSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate device secret: " + err.Error()})
+			return
+		}
+		sealedKey, err = utils.SealDeviceKey(req.BikeID, utils.DeviceKeyFromSecret(s))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not seal device key: " + err.Error()})
+			return
+		}
+		secret = &s
+	}
+
+	var sql string
+	if signed {
+		// Authenticated update (optionally rotating the key)
+		sql = `
+		UPDATE bikes SET
+			metadata = $2,
+			last_seen_at = NOW(),
+			device_key_enc = COALESCE($3, device_key_enc),
+			credentials_issued_at = CASE WHEN $3::BYTEA IS NULL THEN credentials_issued_at ELSE NOW() END
+		WHERE bike_id = $1`
+	} else {
+		// Operator upsert of Bike Metadata, but never overwrite a bike that already holds credentials
+		sql = `
+		INSERT INTO bikes (bike_id, metadata, last_seen_at, device_key_enc, credentials_issued_at)
+		VALUES ($1, $2, NOW(), $3, NOW())
+		ON CONFLICT (bike_id)
+		DO UPDATE SET metadata = $2, last_seen_at = NOW(), device_key_enc = $3, credentials_issued_at = NOW()
+		WHERE bikes.device_key_enc IS NULL AND bikes.device_key_hash IS NULL`
+	}
+
+	res, err := db.Pool.Exec(context.Background(), sql, req.BikeID, req.Metadata, sealedKey)
 	if err != nil {
 		log.Printf("Provision error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "provisioned", "bike_id": req.BikeID})
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "bike already has credentials; the bike must sign updates with X-Bike-ID, X-Timestamp, X-Nonce and X-Signature"})
+		return
+	}
+
+	resp := gin.H{"status": "provisioned", "bike_id": req.BikeID}
+	if secret != nil {
+		// Only time the secret is ever shown; the server keeps just the sealed key
+		resp["device_secret"] = *secret
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // --- LIST BIKES HANDLER ---
@@ -115,28 +172,68 @@ func HandleListBikes(c *gin.Context) {
 
 // --- READ HANDLER ---
 
+// Page size bounds for HandleRead
+const (
+	defaultReadLimit = 50
+	maxReadLimit     = 1000
+)
+
+// HandleRead pages through a bike's telemetry, optionally filtered by time
+// range and log type (see parseTelemetryFilter). The cursor is bound to the
+// filters and order it was issued for.
 func HandleRead(c *gin.Context) {
-	bikeID := c.Query("bike_id")
+	filter, err := parseTelemetryFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	cursor := c.Query("cursor")
 
-	limit := 50
+	limit := defaultReadLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(l, maxReadLimit)
+	}
+
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	seekOp := "<"
+	if order == "asc" {
+		seekOp = ">"
+	}
+	filterKey := filter.key(order)
 
 	// Build the Seek Query (Cursor-based Pagination)
-	sql := `SELECT log_id, logged_at, log_type, val_primary, payload 
-			FROM telemetry_logs WHERE bike_id = $1`
-	args := []interface{}{bikeID}
-	argCounter := 2
+	where, args := filter.where()
+	sql := `SELECT log_id, logged_at, log_type, val_primary, payload, gps_flag, ` + strings.Join(typedColumnNames(), ", ") + `
+			FROM telemetry_logs WHERE ` + where
+	argCounter := len(args) + 1
 
 	// Add the cursor condition if it exists
 	if cursor != "" {
-		ts, uuid := utils.DecodeCursor(cursor)
-		// Tuple Comparison: (logged_at, log_id) < ($2, $3)
-		sql += fmt.Sprintf(` AND (logged_at, log_id) < ($%d, $%d)`, argCounter, argCounter+1)
+		ts, uuid, key, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if key != filterKey {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor was issued for a different query"})
+			return
+		}
+		// Tuple Comparison: (logged_at, log_id) < ($n, $n+1), or > when ascending
+		sql += fmt.Sprintf(` AND (logged_at, log_id) %s ($%d, $%d)`, seekOp, argCounter, argCounter+1)
 		args = append(args, ts, uuid)
 		argCounter += 2
 	}
 
-	sql += fmt.Sprintf(` ORDER BY logged_at DESC, log_id DESC LIMIT $%d`, argCounter)
+	sql += fmt.Sprintf(` ORDER BY logged_at %s, log_id %s LIMIT $%d`, order, order, argCounter)
 	args = append(args, limit)
 
 	rows, err := db.Pool.Query(context.Background(), sql, args...)
@@ -146,7 +243,7 @@ func HandleRead(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var data [][]interface{}
+	data := [][]interface{}{}
 	var lastTime time.Time
 	var lastUUID string
 
@@ -155,11 +252,20 @@ func HandleRead(c *gin.Context) {
 		var t time.Time
 		var val int
 		var p []byte // Raw JSON bytes
+		var gpsFlag *string
+		typed := make([]*float64, len(typedColumns))
 
-		rows.Scan(&id, &t, &lType, &val, &p)
+		dest := []interface{}{&id, &t, &lType, &val, &p, &gpsFlag}
+		for i := range typed {
+			dest = append(dest, &typed[i])
+		}
+		rows.Scan(dest...)
 
-		// Append as Compact Row: [uuid, time, type, val, payload]
-		row := []interface{}{id, t.Format(time.RFC3339), lType, val, string(p)}
+		// Append as Compact Row: [uuid, time, type, val, payload, gps_flag, typed columns...]
+		row := []interface{}{id, t.Format(time.RFC3339), lType, val, string(p), gpsFlag}
+		for _, v := range typed {
+			row = append(row, v)
+		}
 		data = append(data, row)
 
 		lastTime = t
@@ -169,13 +275,13 @@ func HandleRead(c *gin.Context) {
 	// Determine next cursor: If we got the full limit, there might be more pages.
 	nextCursor := ""
 	if len(data) == limit {
-		nextCursor = utils.EncodeCursor(lastTime, lastUUID)
+		nextCursor = utils.EncodeCursor(lastTime, lastUUID, filterKey)
 	}
 
 	// Final Compact Response
 	c.JSON(http.StatusOK, gin.H{
 		"next_cursor": nextCursor,
-		"columns":     []string{"uuid", "timestamp", "type", "val_primary", "payload"},
+		"columns":     append([]string{"uuid", "timestamp", "type", "val_primary", "payload", "gps_flag"}, typedColumnNames()...),
 		"data":        data,
 	})
 }
@@ -257,8 +363,11 @@ func HandleDeleteTelemetry(c *gin.Context) {
 	// 1. Check for JSON Body (Bulk Delete by Bike IDs)
 	var req models.DeleteRequest
 	if err := c.ShouldBindJSON(&req); err == nil && len(req.BikeIDs) > 0 {
-		// Delete ALL telemetry for these bikes
-		res, err := db.Pool.Exec(context.Background(), "DELETE FROM telemetry_logs WHERE bike_id = ANY($1)", req.BikeIDs)
+		// Delete ALL telemetry (and what is derived from it) for these bikes
+		res, err := db.Pool.Exec(context.Background(), `WITH r AS (DELETE FROM telemetry_rollups WHERE bike_id = ANY($1)),
+			t AS (DELETE FROM trips WHERE bike_id = ANY($1)),
+			g AS (DELETE FROM geofence_events WHERE bike_id = ANY($1))
+			DELETE FROM telemetry_logs WHERE bike_id = ANY($1)`, req.BikeIDs)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete telemetry: " + err.Error()})
 			return
@@ -274,8 +383,11 @@ func HandleDeleteTelemetry(c *gin.Context) {
 		return
 	}
 
-	// Only delete logs, keep the bike registry
-	res, err := db.Pool.Exec(context.Background(), "DELETE FROM telemetry_logs WHERE bike_id = $1", bikeID)
+	// Only delete logs and what is derived from them, keep the bike registry
+	res, err := db.Pool.Exec(context.Background(), `WITH r AS (DELETE FROM telemetry_rollups WHERE bike_id = $1),
+		t AS (DELETE FROM trips WHERE bike_id = $1),
+		g AS (DELETE FROM geofence_events WHERE bike_id = $1)
+		DELETE FROM telemetry_logs WHERE bike_id = $1`, bikeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete telemetry: " + err.Error()})
 		return
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// telemetryFilter selects a bike's telemetry by time range and log type.
+// It is parsed from the query string by parseTelemetryFilter.
+type telemetryFilter struct {
+	BikeID string
+	From   time.Time // Inclusive; zero = unbounded
+	To     time.Time // Exclusive; zero = unbounded
+	Types  []string  // Sorted; empty = all log types
+}
+
+// parseTelemetryFilter reads bike_id (required), from/to (RFC3339) and type.
+// type may be repeated (?type=A&type=B) or comma separated (?type=A,B).
+func parseTelemetryFilter(c *gin.Context) (telemetryFilter, error) {
+	f := telemetryFilter{BikeID: c.Query("bike_id")}
+	if f.BikeID == "" {
+		return f, fmt.Errorf("bike_id is required")
+	}
+
+	var err error
+	if f.From, err = parseTimeParam(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseTimeParam(c, "to"); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("from must be before to")
+	}
+
+	f.Types = queryList(c, "type")
+	sort.Strings(f.Types)
+
+	return f, nil
+}
+
+// queryList reads a list parameter given repeated (?x=A&x=B) and/or comma
+// separated (?x=A,B), dropping blanks and duplicates
+func queryList(c *gin.Context, name string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range c.QueryArray(name) {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" && !seen[item] {
+				seen[item] = true
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+func parseTimeParam(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+	}
+	return t, nil
+}
+
+// where returns the SQL predicate for the filter and its arguments, numbered
+// from $1. Callers append their own arguments after these.
+func (f telemetryFilter) where() (string, []interface{}) {
+	conds := []string{"bike_id = $1"}
+	args := []interface{}{f.BikeID}
+
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		conds = append(conds, fmt.Sprintf("logged_at >= $%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		conds = append(conds, fmt.Sprintf("logged_at < $%d", len(args)))
+	}
+	if len(f.Types) > 0 {
+		args = append(args, f.Types)
+		conds = append(conds, fmt.Sprintf("log_type = ANY($%d)", len(args)))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// key fingerprints the filter (plus any extra query settings, such as the sort
+// order) for embedding in pagination cursors.
+func (f telemetryFilter) key(extra ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", f.BikeID, formatBound(f.From), formatBound(f.To), strings.Join(f.Types, ","))
+	for _, e := range extra {
+		fmt.Fprintf(h, "\n%s", e)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
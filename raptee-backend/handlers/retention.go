@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/db"
+	"raptee-backend/models"
+)
+
+// --- RETENTION POLICIES ---
+// Policies live in retention_policies; the server prunes expired rows hourly
+// (see db.RunRetention). Shortening a policy deletes data on the next run, so
+// edits need an admin key.
+
+// maxRetainDays caps retain_days at 100 years
+const maxRetainDays = 36500
+
+const retentionColumns = "log_type, retain_days, updated_at, last_run_at, last_run_pruned, total_pruned"
+
+func scanRetentionPolicy(row pgx.Row) (models.RetentionPolicy, error) {
+	var p models.RetentionPolicy
+	err := row.Scan(&p.LogType, &p.RetainDays, &p.UpdatedAt, &p.LastRunAt, &p.LastRunPruned, &p.TotalPruned)
+	return p, err
+}
+
+// HandleListRetention returns every retention policy with its pruning counters.
+// Log types without a policy are kept forever.
+func HandleListRetention(c *gin.Context) {
+	rows, err := db.Pool.Query(c.Request.Context(), "SELECT "+retentionColumns+" FROM retention_policies ORDER BY log_type")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	policies := []models.RetentionPolicy{}
+	for rows.Next() {
+		p, err := scanRetentionPolicy(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": policies})
+}
+
+// HandleSetRetention creates or changes the retention of a registered log type
+func HandleSetRetention(c *gin.Context) {
+	var req struct {
+		RetainDays int `json:"retain_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+		return
+	}
+	logType := c.Param("log_type")
+	if !db.HasLogType(logType) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown log_type"})
+		return
+	}
+	if req.RetainDays < 1 || req.RetainDays > maxRetainDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retain_days must be between 1 and 36500"})
+		return
+	}
+
+	p, err := scanRetentionPolicy(db.Pool.QueryRow(c.Request.Context(), `
+	INSERT INTO retention_policies (log_type, retain_days) VALUES ($1, $2)
+	ON CONFLICT (log_type) DO UPDATE SET retain_days = EXCLUDED.retain_days, updated_at = NOW()
+	RETURNING `+retentionColumns, logType, req.RetainDays))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// HandleDeleteRetention removes a log type's policy, so it is kept forever
+func HandleDeleteRetention(c *gin.Context) {
+	logType := c.Param("log_type")
+	res, err := db.Pool.Exec(c.Request.Context(), "DELETE FROM retention_policies WHERE log_type = $1", logType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Retention policy not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted", "log_type": logType})
+}
+
+// HandleRunRetention starts a pruning pass now instead of waiting for the
+// hourly run. The pass runs in the retention worker, under the server's
+// lifetime rather than the request's, so it returns 202 right away; progress
+// shows in the policies' last_run_at and counters.
+func HandleRunRetention(c *gin.Context) {
+	db.TriggerRetention()
+	c.JSON(http.StatusAccepted, gin.H{"status": "started"})
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/db"
+	"raptee-backend/sketch"
+)
+
+// --- ROLLUPS ---
+// telemetry_rollups holds hourly and daily API_LATENCY aggregates per
+// (bike, log_type, api_call, connection_state). They are updated in the sync
+// transaction, so they never disagree with telemetry_logs, and fleet and
+// per-bike analytics read them instead of raw rows when the window lines up
+// with their buckets.
+
+// Rollup resolutions, in the order they are tried when reading
+var rollupResolutions = []struct {
+	Name  string
+	Width time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+}
+
+// rollupKey identifies one telemetry_rollups row of a bike
+type rollupKey struct {
+	Resolution      string
+	Bucket          int64 // Unix seconds, so keys compare reliably
+	APICall         string
+	ConnectionState string
+}
+
+// rollupRow is the content of one telemetry_rollups row (or a delta to add to it)
+type rollupRow struct {
+	BikeID          string
+	APICall         string
+	ConnectionState string
+	Total           int
+	Success         int
+	NetworkErrors   int
+	ServerErrors    int
+	ClientErrors    int
+	FirstAt         time.Time
+	LastAt          time.Time
+	Latencies       *sketch.Histogram // Successful calls only
+}
+
+// rollupBatch accumulates rollup deltas for one bike's calls before they are
+// written with apply
+type rollupBatch struct {
+	bikeID string
+	deltas map[rollupKey]*rollupRow
+}
+
+func newRollupBatch(bikeID string) *rollupBatch {
+	return &rollupBatch{bikeID: bikeID, deltas: map[rollupKey]*rollupRow{}}
+}
+
+// add counts one API_LATENCY row in every resolution. Rows without a usable
+// payload are skipped, as they are by analytics.
+func (b *rollupBatch) add(loggedAt time.Time, latency int, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	call, ok := parseAPICall(b.bikeID, payload)
+	if !ok {
+		return
+	}
+	success, network, server, client := classifyStatus(call.StatusCode)
+
+	for _, res := range rollupResolutions {
+		key := rollupKey{res.Name, loggedAt.UTC().Truncate(res.Width).Unix(), call.APIName, call.ConnectionState}
+		d := b.deltas[key]
+		if d == nil {
+			d = &rollupRow{BikeID: b.bikeID, APICall: call.APIName, ConnectionState: call.ConnectionState, FirstAt: loggedAt, LastAt: loggedAt, Latencies: sketch.New()}
+			b.deltas[key] = d
+		}
+
+		d.Total++
+		switch {
+		case success:
+			d.Success++
+			d.Latencies.Add(latency)
+		case network:
+			d.NetworkErrors++
+		case server:
+			d.ServerErrors++
+		case client:
+			d.ClientErrors++
+		}
+		if loggedAt.Before(d.FirstAt) {
+			d.FirstAt = loggedAt
+		}
+		if loggedAt.After(d.LastAt) {
+			d.LastAt = loggedAt
+		}
+	}
+}
+
+// apply adds the batch to telemetry_rollups inside tx. Sketches can't be
+// merged in SQL, so the touched rows are created if missing, locked (in key
+// order, so concurrent syncs of a bike can't deadlock), merged here and
+// written back.
+func (b *rollupBatch) apply(ctx context.Context, tx pgx.Tx) error {
+	if len(b.deltas) == 0 {
+		return nil
+	}
+
+	keys := make([]rollupKey, 0, len(b.deltas))
+	for k := range b.deltas {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := keys[i], keys[j]
+		if a.Resolution != c.Resolution {
+			return a.Resolution < c.Resolution
+		}
+		if a.Bucket != c.Bucket {
+			return a.Bucket < c.Bucket
+		}
+		if a.APICall != c.APICall {
+			return a.APICall < c.APICall
+		}
+		return a.ConnectionState < c.ConnectionState
+	})
+
+	resolutions := make([]string, len(keys))
+	buckets := make([]time.Time, len(keys))
+	apiCalls := make([]string, len(keys))
+	states := make([]string, len(keys))
+	for i, k := range keys {
+		resolutions[i], buckets[i], apiCalls[i], states[i] = k.Resolution, time.Unix(k.Bucket, 0).UTC(), k.APICall, k.ConnectionState
+	}
+
+	_, err := tx.Exec(ctx, `
+	INSERT INTO telemetry_rollups (resolution, bucket, bike_id, log_type, api_call, connection_state)
+	SELECT u.resolution, u.bucket, $1::text, 'API_LATENCY', u.api_call, u.connection_state
+	FROM unnest($2::text[], $3::timestamptz[], $4::text[], $5::text[]) AS u(resolution, bucket, api_call, connection_state)
+	ON CONFLICT DO NOTHING`, b.bikeID, resolutions, buckets, apiCalls, states)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+	SELECT r.resolution, r.bucket, r.api_call, r.connection_state, r.latency_sketch
+	FROM telemetry_rollups r
+	JOIN unnest($2::text[], $3::timestamptz[], $4::text[], $5::text[]) AS u(resolution, bucket, api_call, connection_state)
+		ON r.resolution = u.resolution AND r.bucket = u.bucket AND r.api_call = u.api_call AND r.connection_state = u.connection_state
+	WHERE r.bike_id = $1 AND r.log_type = 'API_LATENCY'
+	ORDER BY r.resolution, r.bucket, r.api_call, r.connection_state
+	FOR UPDATE OF r`, b.bikeID, resolutions, buckets, apiCalls, states)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var k rollupKey
+		var bucket time.Time
+		var stored []byte
+		if err := rows.Scan(&k.Resolution, &bucket, &k.APICall, &k.ConnectionState, &stored); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(stored) > 0 {
+			existing := sketch.New()
+			if err := existing.UnmarshalBinary(stored); err != nil {
+				rows.Close()
+				return err
+			}
+			k.Bucket = bucket.Unix()
+			if d := b.deltas[k]; d != nil {
+				d.Latencies.Merge(existing)
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var totals, successes, networks, servers, clients []int64
+	var firsts, lasts []time.Time
+	var sketches [][]byte
+	for _, k := range keys {
+		d := b.deltas[k]
+		enc, err := d.Latencies.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		totals = append(totals, int64(d.Total))
+		successes = append(successes, int64(d.Success))
+		networks = append(networks, int64(d.NetworkErrors))
+		servers = append(servers, int64(d.ServerErrors))
+		clients = append(clients, int64(d.ClientErrors))
+		firsts = append(firsts, d.FirstAt)
+		lasts = append(lasts, d.LastAt)
+		sketches = append(sketches, enc)
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE telemetry_rollups r SET
+		total_calls = r.total_calls + u.total,
+		success_calls = r.success_calls + u.success,
+		network_errors = r.network_errors + u.network,
+		server_errors = r.server_errors + u.server,
+		client_errors = r.client_errors + u.client,
+		first_at = LEAST(r.first_at, u.first_at),
+		last_at = GREATEST(r.last_at, u.last_at),
+		latency_sketch = u.sketch
+	FROM unnest($2::text[], $3::timestamptz[], $4::text[], $5::text[],
+		$6::bigint[], $7::bigint[], $8::bigint[], $9::bigint[], $10::bigint[],
+		$11::timestamptz[], $12::timestamptz[], $13::bytea[])
+		AS u(resolution, bucket, api_call, connection_state, total, success, network, server, client, first_at, last_at, sketch)
+	WHERE r.bike_id = $1 AND r.log_type = 'API_LATENCY'
+		AND r.resolution = u.resolution AND r.bucket = u.bucket
+		AND r.api_call = u.api_call AND r.connection_state = u.connection_state`,
+		b.bikeID, resolutions, buckets, apiCalls, states,
+		totals, successes, networks, servers, clients, firsts, lasts, sketches)
+	return err
+}
+
+// RebuildRollups recomputes a bike's rollups from its raw API_LATENCY rows,
+// e.g. for telemetry stored before rollups existed (see cmd/rollups).
+func RebuildRollups(ctx context.Context, bikeID string) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM telemetry_rollups WHERE bike_id = $1", bikeID); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT logged_at, val_primary, payload FROM telemetry_logs
+		WHERE bike_id = $1 AND log_type = 'API_LATENCY' AND val_primary IS NOT NULL`, bikeID)
+	if err != nil {
+		return 0, err
+	}
+	batch := newRollupBatch(bikeID)
+	n := 0
+	for rows.Next() {
+		var loggedAt time.Time
+		var latency int
+		var payload []byte
+		if err := rows.Scan(&loggedAt, &latency, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch.add(loggedAt, latency, payload)
+		n++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := batch.apply(ctx, tx); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+// rollupSource returns the rollup resolution to read an API_LATENCY window
+// from, or ok false to read raw rows: when the window isn't aligned to a
+// resolution, or filters by network_type (which rollups don't keep). Rollups
+// outlive pruned raw rows, so they still cover windows reaching back before
+// db.RawCutoff.
+func rollupSource(from, to time.Time, networkType string) (resolution string, ok bool) {
+	resolution, ok = rollupResolutionFor(from, to)
+	if !ok || networkType != "" {
+		return "", false
+	}
+	return resolution, true
+}
+
+// retainedFrom moves from up to the time before which raw API_LATENCY rows
+// may have been pruned (db.RawCutoff), and reports whether it moved. Raw reads
+// start there, and the response says so in raw_from.
+func retainedFrom(ctx context.Context, from time.Time) (time.Time, bool, error) {
+	cutoff, err := db.RawCutoff(ctx, "API_LATENCY")
+	if err != nil {
+		return from, false, err
+	}
+	if cutoff.IsZero() || (!from.IsZero() && !from.Before(cutoff)) {
+		return from, false, nil
+	}
+	return cutoff, true, nil
+}
+
+// rollupResolutionFor returns the coarsest resolution whose buckets tile
+// [from, to) exactly (zero bounds are open). ok is false if none does.
+func rollupResolutionFor(from, to time.Time) (resolution string, ok bool) {
+	for _, res := range rollupResolutions {
+		if aligned(from, res.Width) && aligned(to, res.Width) {
+			return res.Name, true
+		}
+	}
+	return "", false
+}
+
+func aligned(t time.Time, width time.Duration) bool {
+	return t.IsZero() || t.UTC().Truncate(width).Equal(t)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"raptee-backend/db"
+	"raptee-backend/models"
+)
+
+// Log types are upper snake case, e.g. API_LATENCY
+var logTypePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+
+// --- SCHEMA REGISTRY HANDLERS ---
+// Writes go to log_schemas; the table's trigger NOTIFYs every instance, which
+// reloads its in-memory copy (see db.ListenForSchemaChanges).
+
+// HandleListSchemas returns every registered log type version
+func HandleListSchemas(c *gin.Context) {
+	querySchemas(c, "SELECT log_type, version, fields, field_defs FROM log_schemas ORDER BY log_type, version")
+}
+
+// HandleGetSchema returns all versions of a single log type
+func HandleGetSchema(c *gin.Context) {
+	querySchemas(c, "SELECT log_type, version, fields, field_defs FROM log_schemas WHERE log_type = $1 ORDER BY version", c.Param("log_type"))
+}
+
+func querySchemas(c *gin.Context, sql string, args ...interface{}) {
+	rows, err := db.Pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	schemas := []models.LogSchema{}
+	for rows.Next() {
+		var s models.LogSchema
+		var names []string
+		if err := rows.Scan(&s.LogType, &s.Version, &names, &s.Fields); err != nil {
+			continue
+		}
+		if len(s.Fields) == 0 {
+			s.Fields = models.UntypedFields(names)
+		}
+		schemas = append(schemas, s)
+	}
+
+	if len(args) > 0 && len(schemas) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": schemas})
+}
+
+// HandleCreateSchema registers a log type version. Without a version, the next
+// one after the latest registered is assigned (1 for a new log type).
+func HandleCreateSchema(c *gin.Context) {
+	var req models.LogSchema
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+		return
+	}
+	if req.Version < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be positive"})
+		return
+	}
+	if err := validateSchema(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sql := `
+	INSERT INTO log_schemas (log_type, version, fields, field_defs)
+	SELECT $1, COALESCE(NULLIF($2, 0), MAX(version) + 1, 1), $3, $4
+	FROM log_schemas WHERE log_type = $1
+	RETURNING version`
+
+	err := db.Pool.QueryRow(context.Background(), sql, req.LogType, req.Version, models.FieldNames(req.Fields), req.Fields).Scan(&req.Version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Schema version already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	reloadSchemas()
+	c.JSON(http.StatusCreated, req)
+}
+
+// HandleUpdateSchema replaces the field definitions of an existing log type version
+func HandleUpdateSchema(c *gin.Context) {
+	var req models.LogSchema
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+		return
+	}
+	req.LogType = c.Param("log_type")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a positive integer"})
+		return
+	}
+	req.Version = version
+	if err := validateSchema(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := db.Pool.Exec(context.Background(), "UPDATE log_schemas SET fields = $3, field_defs = $4 WHERE log_type = $1 AND version = $2",
+		req.LogType, req.Version, models.FieldNames(req.Fields), req.Fields)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found"})
+		return
+	}
+
+	reloadSchemas()
+	c.JSON(http.StatusOK, req)
+}
+
+// HandleDeleteSchema unregisters a log type version. Stored telemetry is kept,
+// but new rows declaring this version will be rejected by sync.
+func HandleDeleteSchema(c *gin.Context) {
+	logType := c.Param("log_type")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a positive integer"})
+		return
+	}
+
+	res, err := db.Pool.Exec(context.Background(), "DELETE FROM log_schemas WHERE log_type = $1 AND version = $2", logType, version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found"})
+		return
+	}
+
+	reloadSchemas()
+	c.JSON(http.StatusOK, gin.H{"status": "deleted", "log_type": logType, "version": version})
+}
+
+func validateSchema(s models.LogSchema) error {
+	if !logTypePattern.MatchString(s.LogType) {
+		return fmt.Errorf("log_type must be upper snake case (e.g. API_LATENCY)")
+	}
+	return validateFieldDefs(s.Fields)
+}
+
+// reloadSchemas refreshes this instance right away; the others follow on NOTIFY
+func reloadSchemas() {
+	if err := db.LoadSchemas(context.Background()); err != nil {
+		log.Printf("Schema reload error: %v", err)
+	}
+}
@@ -2,105 +2,499 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"raptee-backend/db"
+	"raptee-backend/middleware"
 	"raptee-backend/models"
 )
 
-// HandleSync processes telemetry ingestion
+// HandleSync processes telemetry ingestion.
+// The body may be JSON, MessagePack or CBOR (see bindCompactRequest).
 func HandleSync(c *gin.Context) {
 	var req models.CompactRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+	if format, err := bindCompactRequest(c, &req); err != nil {
+		msg := err.Error()
+		if format != "" {
+			msg = "Invalid " + format + " format: " + msg
+		}
+		c.JSON(bindErrorStatus(err), gin.H{"error": msg})
+		return
+	}
+
+	if req.BikeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bike_id is required"})
+		return
+	}
+
+	// The signature only vouches for the bike in X-Bike-ID
+	if req.BikeID != c.GetString(middleware.DeviceBikeIDKey) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "bike_id does not match the signing bike"})
 		return
 	}
 
-	if err := insertTelemetryBatch(req); err != nil {
+	resp, err := InsertTelemetryBatch(c.Request.Context(), req)
+	if err != nil {
 		log.Printf("Sync error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	c.JSON(http.StatusOK, resp)
+}
+
+// telemetryRow is a validated row of CompactRequest.Data, ready to insert
+type telemetryRow struct {
+	LogID         uuid.UUID
+	LoggedAt      time.Time
+	LogType       string
+	SchemaVersion int
+	ValPrimary    int
+	Lng, Lat      float64
+	Located       bool          // False: stored with a NULL location
+	Typed         []interface{} // Values of typedColumns, nil when not sent
+	Payload       []byte        // Expanded payload, already encoded as JSON
+}
+
+// typedColumns are the optional numeric telemetry_logs columns a compact row
+// may carry, recognized by name in CompactRequest.Columns. Values outside
+// [Min, Max] reject the row.
+var typedColumns = []struct {
+	Name     string
+	Min, Max float64
+}{
+	{"val_secondary", -math.MaxFloat64, math.MaxFloat64},
+	{"speed", 0, 1000},          // km/h
+	{"altitude", -1000, 100000}, // Meters
+	{"heading", 0, 360},         // Degrees
+	{"accuracy", 0, math.MaxFloat64},
+	{"battery_pct", 0, 100},
+}
+
+// typedColumnNames lists typedColumns for SQL, in order
+func typedColumnNames() []string {
+	names := make([]string, len(typedColumns))
+	for i, tc := range typedColumns {
+		names[i] = tc.Name
+	}
+	return names
+}
+
+// typedColumnIndex returns the position of a typed column, -1 if unknown
+func typedColumnIndex(name string) int {
+	for i, tc := range typedColumns {
+		if tc.Name == name {
+			return i
+		}
+	}
+	return -1
 }
 
-func insertTelemetryBatch(req models.CompactRequest) error {
-	ctx := context.Background()
+// InsertTelemetryBatch validates every row, inserts the valid ones and
+// reports the rest back by index so the bike can drop only the poison rows.
+//
+// Valid rows are streamed into a temporary staging table with COPY and merged
+// into telemetry_logs with a single INSERT ... SELECT, so a large offline
+// backlog costs a handful of round trips instead of one per row.
+func InsertTelemetryBatch(ctx context.Context, req models.CompactRequest) (models.SyncResponse, error) {
+	resp := models.SyncResponse{Rejected: []models.RowRejection{}}
+
+	// 1. Validate everything up front (no DB work for bad rows)
+	rows, rejected := validateRows(req)
+	resp.Rejected = append(resp.Rejected, rejected...)
+
+	// 2. Insert the good rows
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return resp, err
 	}
 	defer tx.Rollback(ctx)
 
+	// Update Heartbeat first so the FK on telemetry_logs is satisfied for new
+	// bikes. This also locks the bike's row until commit, serializing its syncs.
+	_, err = tx.Exec(ctx, `INSERT INTO bikes (bike_id, last_seen_at) VALUES ($1, NOW()) ON CONFLICT (bike_id) DO UPDATE SET last_seen_at = NOW()`, req.BikeID)
+	if err != nil {
+		return resp, err
+	}
+
+	if len(rows) > 0 {
+		inserted, err := copyTelemetryRows(ctx, tx, req.BikeID, rows)
+		if err != nil {
+			return resp, err
+		}
+		resp.Accepted = len(inserted)
+		resp.Duplicates = len(rows) - resp.Accepted
+
+		// Roll up only what was actually inserted, so resends don't double count,
+		// and note the time span of new rows for the GPS check and of new located
+		// rows for trips and geofences
+		rollups := newRollupBatch(req.BikeID)
+		var firstInserted, lastInserted, firstLocated, lastLocated time.Time
+		for _, r := range rows {
+			key := telemetryKey{r.LogID, r.LoggedAt.UnixMicro()}
+			if inserted[key] {
+				if firstInserted.IsZero() || r.LoggedAt.Before(firstInserted) {
+					firstInserted = r.LoggedAt
+				}
+				if r.LoggedAt.After(lastInserted) {
+					lastInserted = r.LoggedAt
+				}
+				if r.LogType == "API_LATENCY" {
+					rollups.add(r.LoggedAt, r.ValPrimary, r.Payload)
+				}
+				if r.Located {
+					if firstLocated.IsZero() || r.LoggedAt.Before(firstLocated) {
+						firstLocated = r.LoggedAt
+					}
+					if r.LoggedAt.After(lastLocated) {
+						lastLocated = r.LoggedAt
+					}
+				}
+			}
+			delete(inserted, key) // A row repeated within the batch is stored once
+		}
+		if err := rollups.apply(ctx, tx); err != nil {
+			return resp, err
+		}
+		if !firstInserted.IsZero() {
+			if err := checkGPS(ctx, tx, req.BikeID, firstInserted, lastInserted); err != nil {
+				return resp, err
+			}
+		}
+		if !firstLocated.IsZero() {
+			if err := segmentTrips(ctx, tx, req.BikeID, firstLocated, lastLocated); err != nil {
+				return resp, err
+			}
+			if err := detectGeofenceEvents(ctx, tx, req.BikeID, firstLocated, lastLocated); err != nil {
+				return resp, err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return resp, err
+	}
+
+	resp.Status = "success"
+	if len(resp.Rejected) > 0 {
+		resp.Status = "partial"
+	}
+	return resp, nil
+}
+
+// telemetryKey identifies a bike's row: its log_id and logged_at in
+// microseconds (Postgres' precision)
+type telemetryKey struct {
+	LogID    uuid.UUID
+	LoggedAt int64
+}
+
+// validateRows parses every row of the batch. Rows that would fail the COPY
+// (and with it the whole batch) must be rejected here, with their index.
+func validateRows(req models.CompactRequest) ([]telemetryRow, []models.RowRejection) {
 	// Map columns to indices for dynamic parsing
 	colMap := make(map[string]int)
 	for i, col := range req.Columns {
 		colMap[col] = i
 	}
 
-	get := func(row []interface{}, col string) interface{} {
-		if idx, ok := colMap[col]; ok && idx < len(row) {
-			return row[idx]
+	// 0 (undeclared) lets each row's payload pick its version
+	batchVersion := req.SchemaVersion
+
+	var rows []telemetryRow
+	rejected := []models.RowRejection{}
+	for i, raw := range req.Data {
+		row, err := parseRow(colMap, raw, batchVersion)
+		if err != nil {
+			rejected = append(rejected, models.RowRejection{Index: i, Reason: err.Error()})
+			continue
 		}
-		return nil
+		rows = append(rows, row)
+	}
+	return rows, rejected
+}
+
+// copyTelemetryRows bulk loads rows into a transaction-scoped staging table and
+// merges them into telemetry_logs, skipping any log_id the bike already stored
+// (or repeats within the batch) whatever its timestamp. The caller holds the
+// bike's row lock, so concurrent syncs of a bike can't both insert a log_id.
+// ON CONFLICT DO NOTHING backs this with the (bike_id, logged_at, log_id)
+// unique index. The returned set excludes duplicates.
+func copyTelemetryRows(ctx context.Context, tx pgx.Tx, bikeID string, rows []telemetryRow) (map[telemetryKey]bool, error) {
+	_, err := tx.Exec(ctx, `
+	CREATE TEMP TABLE telemetry_staging (
+		log_id UUID,
+		logged_at TIMESTAMPTZ,
+		log_type TEXT,
+		schema_version INTEGER,
+		val_primary INTEGER,
+		lng DOUBLE PRECISION,
+		lat DOUBLE PRECISION,
+		payload JSONB,
+		val_secondary DOUBLE PRECISION,
+		speed DOUBLE PRECISION,
+		altitude DOUBLE PRECISION,
+		heading DOUBLE PRECISION,
+		accuracy DOUBLE PRECISION,
+		battery_pct DOUBLE PRECISION
+	) ON COMMIT DROP`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"telemetry_staging"},
+		append([]string{"log_id", "logged_at", "log_type", "schema_version", "val_primary", "lng", "lat", "payload"}, typedColumnNames()...),
+		pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+			r := rows[i]
+			var lng, lat interface{}
+			if r.Located {
+				lng, lat = r.Lng, r.Lat
+			}
+			return append([]interface{}{r.LogID, r.LoggedAt, r.LogType, r.SchemaVersion, r.ValPrimary, lng, lat, r.Payload}, r.Typed...), nil
+		}),
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	sql := `
 	INSERT INTO telemetry_logs (
-		log_id, bike_id, logged_at, log_type, val_primary, location, payload
-	) VALUES (
-		$1, $2, $3, $4, $5, ST_SetSRID(ST_MakePoint($6, $7), 4326), $8
-	) ON CONFLICT (bike_id, log_id) DO NOTHING`
+		log_id, bike_id, logged_at, log_type, schema_version, val_primary, location, payload,
+		val_secondary, speed, altitude, heading, accuracy, battery_pct
+	)
+	SELECT DISTINCT ON (log_id) log_id, $1, logged_at, log_type, schema_version, val_primary, ST_SetSRID(ST_MakePoint(lng, lat), 4326), payload,
+		val_secondary, speed, altitude, heading, accuracy, battery_pct
+	FROM telemetry_staging s
+	WHERE NOT EXISTS (SELECT 1 FROM telemetry_logs t WHERE t.bike_id = $1 AND t.log_id = s.log_id)
+	ORDER BY log_id, logged_at
+	ON CONFLICT (bike_id, logged_at, log_id) DO NOTHING
+	RETURNING log_id, logged_at`
 
-	for _, row := range req.Data {
-		uuid, _ := get(row, "uuid").(string)
-		tsStr, _ := get(row, "timestamp").(string)
-		lType, _ := get(row, "type").(string)
-		rawPayload := get(row, "payload")
+	inserted, err := tx.Query(ctx, sql, bikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer inserted.Close()
+
+	ids := make(map[telemetryKey]bool, len(rows))
+	for inserted.Next() {
+		var id uuid.UUID
+		var at time.Time
+		if err := inserted.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		ids[telemetryKey{id, at.UnixMicro()}] = true
+	}
+	return ids, inserted.Err()
+}
 
-		finalPayload := expandPayload(lType, rawPayload)
+// parseRow extracts and validates a single compact row.
+// The returned error message is sent back to the bike as the rejection reason.
+func parseRow(colMap map[string]int, raw []interface{}, batchVersion int) (telemetryRow, error) {
+	var row telemetryRow
 
-		var valPrimary int
-		if v, ok := get(row, "val_primary").(float64); ok {
-			valPrimary = int(v)
+	get := func(col string) (interface{}, bool) {
+		if idx, ok := colMap[col]; ok && idx < len(raw) && raw[idx] != nil {
+			return raw[idx], true
 		}
+		return nil, false
+	}
+
+	// UUID (Idempotency key)
+	id, _ := get("uuid")
+	idStr, ok := id.(string)
+	if !ok {
+		return row, fmt.Errorf("uuid is missing or not a string")
+	}
+	logID, err := uuid.Parse(idStr)
+	if err != nil {
+		return row, fmt.Errorf("invalid uuid %q", idStr)
+	}
+	row.LogID = logID
+
+	// Timestamp
+	ts, _ := get("timestamp")
+	tsStr, ok := ts.(string)
+	if !ok {
+		return row, fmt.Errorf("timestamp is missing or not a string")
+	}
+	t, err := time.Parse(time.RFC3339, tsStr)
+	if err != nil {
+		return row, fmt.Errorf("invalid RFC3339 timestamp %q", tsStr)
+	}
+	row.LoggedAt = t
 
-		var lng, lat float64
-		if v, ok := get(row, "lng").(float64); ok {
-			lng = v
+	// Log Type + Schema Version (must be registered)
+	lt, _ := get("type")
+	lType, ok := lt.(string)
+	if !ok || lType == "" {
+		return row, fmt.Errorf("type is missing or not a string")
+	}
+	if !db.HasLogType(lType) {
+		return row, fmt.Errorf("unknown log type %q", lType)
+	}
+	row.LogType = lType
+
+	row.SchemaVersion = batchVersion
+	if v, present := get("schema_version"); present {
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) || f < 1 {
+			return row, fmt.Errorf("schema_version must be a positive integer")
 		}
-		if v, ok := get(row, "lat").(float64); ok {
-			lat = v
+		row.SchemaVersion = int(f)
+	}
+	if row.SchemaVersion == 0 {
+		// Undeclared: a payload array identifies its layout by length, so
+		// firmware that never sent a version keeps working across layouts
+		row.SchemaVersion = db.DefaultSchemaVersion
+		if p, ok := get("payload"); ok {
+			if arr, ok := p.([]interface{}); ok {
+				if v, ok := db.SchemaVersionForFields(lType, len(arr)); ok {
+					row.SchemaVersion = v
+				}
+			}
 		}
+	}
+	fields, known := db.GetSchema(lType, row.SchemaVersion)
+	if !known {
+		return row, fmt.Errorf("unknown schema version %d for log type %q", row.SchemaVersion, lType)
+	}
 
-		_, err := tx.Exec(ctx, sql, uuid, req.BikeID, tsStr, lType, valPrimary, lng, lat, finalPayload)
-		if err != nil {
-			return err
+	// Primary Value (optional)
+	if v, present := get("val_primary"); present {
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+			return row, fmt.Errorf("val_primary must be a whole number within the 32-bit integer range")
+		}
+		row.ValPrimary = int(f)
+	}
+
+	// Location (optional, but must be sane when sent). A row needs both
+	// coordinates to be located; NaN (possible in MessagePack/CBOR) and (0,0),
+	// which GPS modules report without a fix, are stored without a location.
+	latSent, lngSent := false, false
+	if v, present := get("lat"); present {
+		f, ok := v.(float64)
+		if !ok || f < -90 || f > 90 {
+			return row, fmt.Errorf("lat must be a number between -90 and 90")
+		}
+		row.Lat, latSent = f, true
+	}
+	if v, present := get("lng"); present {
+		f, ok := v.(float64)
+		if !ok || f < -180 || f > 180 {
+			return row, fmt.Errorf("lng must be a number between -180 and 180")
+		}
+		row.Lng, lngSent = f, true
+	}
+	row.Located = latSent && lngSent && !math.IsNaN(row.Lat) && !math.IsNaN(row.Lng) && (row.Lat != 0 || row.Lng != 0)
+
+	// Typed columns (optional)
+	row.Typed = make([]interface{}, len(typedColumns))
+	for i, tc := range typedColumns {
+		v, present := get(tc.Name)
+		if !present {
+			continue
+		}
+		f, ok := v.(float64)
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			return row, fmt.Errorf("%s must be a finite number", tc.Name)
 		}
+		if f < tc.Min || f > tc.Max {
+			return row, fmt.Errorf("%s must be between %g and %g", tc.Name, tc.Min, tc.Max)
+		}
+		row.Typed[i] = f
 	}
 
-	// Update Heartbeat
-	db.Pool.Exec(ctx, `INSERT INTO bikes (bike_id, last_seen_at) VALUES ($1, NOW()) ON CONFLICT (bike_id) DO UPDATE SET last_seen_at = NOW()`, req.BikeID)
+	// Encode here so a payload JSONB can't hold is rejected with its row,
+	// instead of failing the COPY for the whole batch.
+	if rawPayload, present := get("payload"); present {
+		expanded, err := expandPayload(fields, rawPayload)
+		if err != nil {
+			return row, fmt.Errorf("%s v%d: %v", lType, row.SchemaVersion, err)
+		}
+		if hasNUL(expanded) {
+			return row, fmt.Errorf("payload must not contain NUL (\\u0000) characters")
+		}
+		payload, err := json.Marshal(expanded)
+		if err != nil {
+			return row, fmt.Errorf("payload is not JSON encodable: %v", err)
+		}
+		row.Payload = payload
+	}
 
-	return tx.Commit(ctx)
+	return row, nil
 }
 
-func expandPayload(logType string, rawPayload interface{}) interface{} {
-	// Check if rawPayload is a slice (JSON Array)
-	if payloadVals, ok := rawPayload.([]interface{}); ok {
-		if keys, hasSchema := db.GlobalSchemas[logType]; hasSchema {
-			// Zip Keys + Values
-			expanded := make(map[string]interface{})
-			for i, val := range payloadVals {
-				if i < len(keys) {
-					expanded[keys[i]] = val
-				}
+// hasNUL reports whether a decoded payload has a NUL character in any string
+// or key. JSON can encode it but JSONB can't store it.
+func hasNUL(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return strings.ContainsRune(v, 0)
+	case map[string]interface{}:
+		for k, e := range v {
+			if strings.ContainsRune(k, 0) || hasNUL(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if hasNUL(e) {
+				return true
 			}
-			return expanded
 		}
 	}
-	return rawPayload
+	return false
+}
+
+// expandPayload zips a compact payload array with the schema's fields and
+// coerces each value to its declared type. Objects are checked the same way,
+// by key; keys the schema doesn't know are kept as sent. An array whose length
+// doesn't match the schema means the bike and server disagree on the layout,
+// so it is rejected rather than silently truncated or mislabelled.
+func expandPayload(fields []models.FieldDef, rawPayload interface{}) (interface{}, error) {
+	var expanded map[string]interface{}
+	switch p := rawPayload.(type) {
+	case []interface{}:
+		if len(p) != len(fields) {
+			return nil, fmt.Errorf("payload has %d values but schema has %d fields", len(p), len(fields))
+		}
+		// Zip Keys + Values
+		expanded = make(map[string]interface{}, len(fields))
+		for i, val := range p {
+			expanded[fields[i].Name] = val
+		}
+	case map[string]interface{}:
+		expanded = p
+	default:
+		return nil, fmt.Errorf("payload must be an array or an object")
+	}
+
+	// Report every bad field at once so the firmware fix is obvious
+	var problems []string
+	for _, def := range fields {
+		val, err := coerceField(def, expanded[def.Name])
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if _, sent := expanded[def.Name]; sent {
+			expanded[def.Name] = val
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return expanded, nil
 }
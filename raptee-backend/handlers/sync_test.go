@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"raptee-backend/db"
+	"raptee-backend/models"
+)
+
+// A row the database can't store must be rejected on its own, never fail the
+// batch (and with it every resend of the bike's buffer).
+func TestValidateRowsRejectsPoisonRows(t *testing.T) {
+	db.SetSchemas(map[db.SchemaKey][]models.FieldDef{
+		{LogType: "TEST_LOG", Version: 1}: models.UntypedFields([]string{"note"}),
+	})
+
+	row := func(val interface{}, note string) []interface{} {
+		return []interface{}{uuid.New().String(), "2025-11-28T09:00:00Z", "TEST_LOG", val, []interface{}{note}}
+	}
+	req := models.CompactRequest{
+		BikeID:  "TEST_BIKE",
+		Columns: []string{"uuid", "timestamp", "type", "val_primary", "payload"},
+		Data: [][]interface{}{
+			row(120.0, "ok"),
+			row(3e10, "ok"),      // Outside INTEGER
+			row(12.5, "ok"),      // Not whole
+			row(120.0, "a\x00b"), // JSONB can't hold NUL
+			row(-7.0, "ok"),
+		},
+	}
+
+	rows, rejected := validateRows(req)
+	if len(rows) != 2 || rows[0].ValPrimary != 120 || rows[1].ValPrimary != -7 {
+		t.Fatalf("expected rows 0 and 4 to be valid, got %+v", rows)
+	}
+	want := []int{1, 2, 3}
+	if len(rejected) != len(want) {
+		t.Fatalf("expected %d rejections, got %+v", len(want), rejected)
+	}
+	for i, r := range rejected {
+		if r.Index != want[i] || r.Reason == "" {
+			t.Errorf("rejection %d: expected index %d with a reason, got %+v", i, want[i], r)
+		}
+	}
+}
+
+// Rows without a declared version pick the layout their payload length
+// matches, so firmware that never sent schema_version survives a new layout.
+func TestValidateRowsInfersUndeclaredVersion(t *testing.T) {
+	db.SetSchemas(map[db.SchemaKey][]models.FieldDef{
+		{LogType: "TEST_LOG", Version: 1}: models.UntypedFields([]string{"a", "b"}),
+		{LogType: "TEST_LOG", Version: 2}: models.UntypedFields([]string{"a", "b", "c"}),
+	})
+
+	row := func(payload interface{}) []interface{} {
+		return []interface{}{uuid.New().String(), "2025-11-28T09:00:00Z", "TEST_LOG", payload}
+	}
+	req := models.CompactRequest{
+		BikeID:  "TEST_BIKE",
+		Columns: []string{"uuid", "timestamp", "type", "payload"},
+		Data: [][]interface{}{
+			row([]interface{}{"x", "y"}),
+			row([]interface{}{"x", "y", "z"}),
+			row(map[string]interface{}{"a": "x"}),
+			row([]interface{}{"x"}), // Matches no version
+		},
+	}
+
+	rows, rejected := validateRows(req)
+	if len(rows) != 3 || rows[0].SchemaVersion != 1 || rows[1].SchemaVersion != 2 || rows[2].SchemaVersion != 1 {
+		t.Fatalf("expected versions 1, 2, 1, got %+v", rows)
+	}
+	if len(rejected) != 1 || rejected[0].Index != 3 {
+		t.Fatalf("expected row 3 rejected, got %+v", rejected)
+	}
+
+	// A declared batch version is not second-guessed
+	req.SchemaVersion = 1
+	if _, rejected := validateRows(req); len(rejected) != 2 {
+		t.Fatalf("expected rows 1 and 3 rejected under version 1, got %+v", rejected)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"raptee-backend/db"
+)
+
+// Vector tile limits. Tiles are built at the standard 4096 extent; a tile
+// holds at most the latest maxTilePoints points (use the heatmap for
+// overviews).
+const (
+	maxTileZoom   = 22
+	maxTilePoints = 50000
+	tileExtent    = 4096
+	tileBuffer    = 64
+	tileLayerName = "telemetry"
+	mvtMediaType  = "application/vnd.mapbox-vector-tile"
+)
+
+// parseTileCoords reads the z/x/y path params (y carrying the .mvt suffix)
+func parseTileCoords(c *gin.Context) (z, x, y int, err error) {
+	yParam, ok := strings.CutSuffix(c.Param("y"), ".mvt")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("tile path must be /tiles/{z}/{x}/{y}.mvt")
+	}
+	z, errZ := strconv.Atoi(c.Param("z"))
+	x, errX := strconv.Atoi(c.Param("x"))
+	y, errY := strconv.Atoi(yParam)
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > maxTileZoom {
+		return 0, 0, 0, fmt.Errorf("z, x and y must be integers with z from 0 to %d", maxTileZoom)
+	}
+	if n := 1 << z; x < 0 || x >= n || y < 0 || y >= n {
+		return 0, 0, 0, fmt.Errorf("x and y must be from 0 to %d at zoom %d", (1<<z)-1, z)
+	}
+	return z, x, y, nil
+}
+
+// HandleTile serves one Mapbox Vector Tile (web mercator z/x/y) of located
+// telemetry points, built by PostGIS ST_AsMVT. Every point of the
+// "telemetry" layer carries bike_id, log_type, val_primary, logged_at (unix
+// seconds), gps_flag and, for API_LATENCY rows, status and status_code.
+//
+// Accepts the bike_id, type and from/to filters of parseGeoFilter. Empty
+// tiles are returned as 200 with an empty body.
+func HandleTile(c *gin.Context) {
+	z, x, y, err := parseTileCoords(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseGeoFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where, args := filter.where()
+	args = append(args, z, x, y)
+	n := len(args)
+	sql := fmt.Sprintf(`
+	WITH tile AS (
+		SELECT ST_TileEnvelope($%[2]d, $%[3]d, $%[4]d) AS merc
+	),
+	bounds AS (
+		-- Points within the MVT buffer of the edge are kept by ST_AsMVTGeom,
+		-- so markers straddling tiles are drawn whole on both
+		SELECT merc, ST_Transform(ST_Expand(merc, (ST_XMax(merc) - ST_XMin(merc)) * %[6]d / %[5]d), 4326) AS buffered
+		FROM tile
+	),
+	points AS (
+		SELECT ST_AsMVTGeom(ST_Transform(location::geometry, 3857), bounds.merc, %[5]d, %[6]d, true) AS geom,
+			bike_id,
+			log_type,
+			val_primary,
+			extract(epoch FROM logged_at)::bigint AS logged_at,
+			gps_flag,
+			CASE WHEN log_type = 'API_LATENCY' THEN COALESCE(payload->>'status', payload->>1) END AS status,
+			CASE WHEN log_type = 'API_LATENCY' THEN COALESCE(payload->>'status_code', payload->>2) END AS status_code
+		FROM telemetry_logs, bounds
+		WHERE %[1]s AND location::geometry && bounds.buffered
+		ORDER BY telemetry_logs.logged_at DESC, log_id DESC
+		LIMIT %[7]d
+	)
+	SELECT ST_AsMVT(points, '%[8]s', %[5]d, 'geom') FROM points`,
+		where, n-2, n-1, n, tileExtent, tileBuffer, maxTilePoints, tileLayerName)
+
+	var tile []byte
+	if err := db.Pool.QueryRow(context.Background(), sql, args...).Scan(&tile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, mvtMediaType, tile)
+}
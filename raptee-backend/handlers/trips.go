@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/db"
+)
+
+// --- TRIPS ---
+// A trip is a run of a bike's located telemetry rows with no time gap longer
+// than tripMaxGap and no jump faster than tripMaxSpeed between consecutive
+// points. Runs shorter than tripMinDistance (a parked bike still reporting)
+// are not trips. Trips are re-segmented around every synced batch, in the sync
+// transaction, so late uploads extend or merge the trips they belong to.
+
+const (
+	tripMaxGap      = 5 * time.Minute
+	tripMaxSpeed    = 200 / 3.6 // m/s; anything faster is a GPS jump, not riding
+	tripMinDistance = 200.0     // meters
+	earthRadius     = 6371008.8 // meters (mean)
+
+	defaultTripLimit = 50
+	maxTripLimit     = 1000
+	maxTripRows      = 5000 // Telemetry rows returned with a trip
+)
+
+// tripNamespace derives stable trip ids from (bike_id, started_at), so
+// re-segmenting a trip that didn't change keeps its id
+var tripNamespace = uuid.MustParse("8c4a4c1e-3f3b-4d55-9a55-2f0b6a1f7e10")
+
+// TripSummary is one row of GET /api/v1/bikes/:bike_id/trips
+type TripSummary struct {
+	TripID     string   `json:"trip_id"`
+	BikeID     string   `json:"bike_id"`
+	StartedAt  string   `json:"started_at"`
+	EndedAt    string   `json:"ended_at"`
+	DurationS  float64  `json:"duration_s"`
+	DistanceM  float64  `json:"distance_m"`
+	PointCount int      `json:"point_count"`
+	Start      GeoPoint `json:"start"`
+	End        GeoPoint `json:"end"`
+}
+
+// GeoPoint is a WGS84 position
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// TripDetailResponse is GET /api/v1/bikes/:bike_id/trips/:trip_id: the trip,
+// its path as a GeoJSON LineString, and every telemetry row logged during it
+// (located or not) in the compact format of HandleRead plus lat/lng.
+type TripDetailResponse struct {
+	TripSummary
+	Path      json.RawMessage `json:"path"`
+	Columns   []string        `json:"columns"`
+	Data      [][]interface{} `json:"data"`
+	Truncated bool            `json:"truncated"` // More than maxTripRows rows
+}
+
+type tripPoint struct {
+	At       time.Time
+	Lat, Lng float64
+}
+
+// distance returns the great-circle distance between two points in meters
+func distance(a, b tripPoint) float64 {
+	const rad = math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLng := (b.Lng - a.Lng) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(1, h)))
+}
+
+type trip struct {
+	points   []tripPoint
+	distance float64
+}
+
+// segmentTripPoints splits time-ordered points into trips
+func segmentTripPoints(points []tripPoint) []trip {
+	var trips []trip
+	var cur trip
+	flush := func() {
+		if len(cur.points) > 1 && cur.distance >= tripMinDistance {
+			trips = append(trips, cur)
+		}
+		cur = trip{}
+	}
+
+	for _, p := range points {
+		if n := len(cur.points); n > 0 {
+			prev := cur.points[n-1]
+			gap := p.At.Sub(prev.At)
+			d := distance(prev, p)
+			if gap > tripMaxGap || d > tripMaxSpeed*math.Max(gap.Seconds(), 1) {
+				flush()
+			} else {
+				cur.distance += d
+			}
+		}
+		cur.points = append(cur.points, p)
+	}
+	flush()
+	return trips
+}
+
+// segmentTrips re-segments a bike's trips around [from, to] inside tx: trips
+// within tripMaxGap of the window are deleted and rebuilt together with it
+func segmentTrips(ctx context.Context, tx pgx.Tx, bikeID string, from, to time.Time) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('trips:' || $1))", bikeID); err != nil {
+		return err
+	}
+
+	lo, hi := from.Add(-tripMaxGap), to.Add(tripMaxGap)
+	err := tx.QueryRow(ctx, `
+	SELECT LEAST($2, MIN(started_at)), GREATEST($3, MAX(ended_at))
+	FROM trips WHERE bike_id = $1 AND started_at <= $3 AND ended_at >= $2`, bikeID, lo, hi).Scan(&lo, &hi)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM trips WHERE bike_id = $1 AND started_at <= $3 AND ended_at >= $2", bikeID, lo, hi); err != nil {
+		return err
+	}
+
+	_, err = storeTrips(ctx, tx, bikeID, lo, hi)
+	return err
+}
+
+// storeTrips segments the bike's located rows in [lo, hi] (zero = unbounded),
+// leaving out fixes flagged by the GPS check, and inserts the trips found
+func storeTrips(ctx context.Context, tx pgx.Tx, bikeID string, lo, hi time.Time) (int, error) {
+	sql := `SELECT logged_at, ST_Y(location::geometry), ST_X(location::geometry)
+		FROM telemetry_logs WHERE bike_id = $1 AND location IS NOT NULL AND (gps_flag IS NULL OR gps_flag = 'ok')`
+	args := []interface{}{bikeID}
+	if !lo.IsZero() {
+		args = append(args, lo)
+		sql += fmt.Sprintf(" AND logged_at >= $%d", len(args))
+	}
+	if !hi.IsZero() {
+		args = append(args, hi)
+		sql += fmt.Sprintf(" AND logged_at <= $%d", len(args))
+	}
+	sql += " ORDER BY logged_at, log_id"
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	var points []tripPoint
+	for rows.Next() {
+		var p tripPoint
+		if err := rows.Scan(&p.At, &p.Lat, &p.Lng); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		points = append(points, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	trips := segmentTripPoints(points)
+	for _, t := range trips {
+		first, last := t.points[0], t.points[len(t.points)-1]
+		lngs := make([]float64, len(t.points))
+		lats := make([]float64, len(t.points))
+		for i, p := range t.points {
+			lngs[i], lats[i] = p.Lng, p.Lat
+		}
+		id := uuid.NewSHA1(tripNamespace, []byte(bikeID+"/"+first.At.UTC().Format(time.RFC3339Nano)))
+
+		_, err := tx.Exec(ctx, `
+		INSERT INTO trips (trip_id, bike_id, started_at, ended_at, start_point, end_point, distance_m, point_count, path)
+		SELECT $1, $2, $3, $4,
+			ST_SetSRID(ST_MakePoint($5, $6), 4326)::geography,
+			ST_SetSRID(ST_MakePoint($7, $8), 4326)::geography,
+			$9, $10,
+			ST_SetSRID(ST_MakeLine(ST_MakePoint(p.lng, p.lat) ORDER BY p.i), 4326)::geography
+		FROM unnest($11::float8[], $12::float8[]) WITH ORDINALITY AS p(lng, lat, i)`,
+			id, bikeID, first.At, last.At, first.Lng, first.Lat, last.Lng, last.Lat,
+			t.distance, len(t.points), lngs, lats)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(trips), nil
+}
+
+// RebuildTrips re-segments all of a bike's telemetry into trips, e.g. for
+// telemetry stored before trips existed (see cmd/trips).
+func RebuildTrips(ctx context.Context, bikeID string) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('trips:' || $1))", bikeID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM trips WHERE bike_id = $1", bikeID); err != nil {
+		return 0, err
+	}
+	n, err := storeTrips(ctx, tx, bikeID, time.Time{}, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+const tripColumns = `trip_id::text, bike_id, started_at, ended_at, distance_m, point_count,
+	ST_Y(start_point::geometry), ST_X(start_point::geometry), ST_Y(end_point::geometry), ST_X(end_point::geometry)`
+
+func scanTrip(row pgx.Row, extra ...interface{}) (TripSummary, error) {
+	var t TripSummary
+	var started, ended time.Time
+	dest := append([]interface{}{&t.TripID, &t.BikeID, &started, &ended, &t.DistanceM, &t.PointCount,
+		&t.Start.Lat, &t.Start.Lng, &t.End.Lat, &t.End.Lng}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return t, err
+	}
+	t.StartedAt = started.UTC().Format(time.RFC3339)
+	t.EndedAt = ended.UTC().Format(time.RFC3339)
+	t.DurationS = ended.Sub(started).Seconds()
+	return t, nil
+}
+
+// HandleListTrips lists a bike's trips, newest first. from/to (RFC3339)
+// select trips overlapping the window; limit defaults to 50 (max 1000).
+func HandleListTrips(c *gin.Context) {
+	bikeID := c.Param("bike_id")
+	from, err := parseTimeParam(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTimeParam(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := defaultTripLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(l, maxTripLimit)
+	}
+
+	sql := "SELECT " + tripColumns + " FROM trips WHERE bike_id = $1"
+	args := []interface{}{bikeID}
+	if !from.IsZero() {
+		args = append(args, from)
+		sql += fmt.Sprintf(" AND ended_at >= $%d", len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		sql += fmt.Sprintf(" AND started_at < $%d", len(args))
+	}
+	args = append(args, limit)
+	sql += fmt.Sprintf(" ORDER BY started_at DESC LIMIT $%d", len(args))
+
+	rows, err := db.Pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	trips := []TripSummary{}
+	for rows.Next() {
+		t, err := scanTrip(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		trips = append(trips, t)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bike_id": bikeID, "trips": trips})
+}
+
+// HandleGetTrip returns one trip with its path and the telemetry logged
+// during it (up to maxTripRows rows, oldest first)
+func HandleGetTrip(c *gin.Context) {
+	bikeID := c.Param("bike_id")
+	tripID, err := uuid.Parse(c.Param("trip_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "trip_id must be a UUID"})
+		return
+	}
+
+	var resp TripDetailResponse
+	var path []byte
+	resp.TripSummary, err = scanTrip(db.Pool.QueryRow(context.Background(),
+		"SELECT "+tripColumns+", ST_AsGeoJSON(path) FROM trips WHERE bike_id = $1 AND trip_id = $2", bikeID, tripID), &path)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	resp.Path = path
+
+	rows, err := db.Pool.Query(context.Background(), `
+	SELECT l.log_id::text, l.logged_at, l.log_type, l.val_primary, l.payload,
+		ST_Y(l.location::geometry), ST_X(l.location::geometry)
+	FROM telemetry_logs l
+	JOIN trips t ON t.bike_id = l.bike_id AND t.trip_id = $2
+	WHERE l.bike_id = $1 AND l.logged_at BETWEEN t.started_at AND t.ended_at
+	ORDER BY l.logged_at, l.log_id
+	LIMIT $3`, bikeID, tripID, maxTripRows+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	resp.Columns = []string{"uuid", "timestamp", "type", "val_primary", "payload", "lat", "lng"}
+	resp.Data = [][]interface{}{}
+	for rows.Next() {
+		var id, lType string
+		var t time.Time
+		var val *int
+		var p []byte
+		var lat, lng *float64
+		if err := rows.Scan(&id, &t, &lType, &val, &p, &lat, &lng); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+		if len(resp.Data) == maxTripRows {
+			resp.Truncated = true
+			break
+		}
+		resp.Data = append(resp.Data, []interface{}{id, t.Format(time.RFC3339), lType, val, string(p), lat, lng})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -1,49 +1,181 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"raptee-backend/db"
+	"raptee-backend/grpcserver"
 	"raptee-backend/handlers"
+	"raptee-backend/middleware"
+	"raptee-backend/utils"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish on SIGTERM
+const shutdownTimeout = 30 * time.Second
+
 // --- MAIN FUNCTION ---
 
 func main() {
 	// Load .env file and force override existing env vars
 	_ = godotenv.Overload()
 
+	// Cancelled on SIGINT/SIGTERM: stops the background workers and starts the
+	// graceful shutdown at the end of main
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 1. Database Connection & Schema Loading
 	db.Init()
 	defer db.Pool.Close()
+	if err := utils.InitDeviceKeyCipher(os.Getenv("DEVICE_KEY_ENCRYPTION_KEY")); err != nil {
+		log.Fatalf("Device key encryption: %v", err)
+	}
+	if n, err := db.SealLegacyDeviceKeys(ctx); err != nil {
+		log.Fatalf("Could not seal legacy device keys: %v", err)
+	} else if n > 0 {
+		log.Printf("Sealed %d plaintext device keys", n)
+	}
+	if err := handlers.LoadGPSRegion(); err != nil {
+		log.Fatalf("Invalid GPS region: %v", err)
+	}
+	partitions, err := db.LoadPartitionConfig()
+	if err != nil {
+		log.Fatalf("Invalid partition config: %v", err)
+	}
+	var workers sync.WaitGroup
+	for _, run := range []func(context.Context){
+		db.ListenForSchemaChanges, // Live schema registry updates
+		func(ctx context.Context) { db.RunPartitionMaintenance(ctx, partitions) }, // telemetry_logs monthly partitions
+		db.RunRetention, // Per log type retention policies
+	} {
+		workers.Add(1)
+		go func(run func(context.Context)) {
+			defer workers.Done()
+			run(ctx)
+		}(run)
+	}
 
 	// 2. Router Setup
 	r := gin.Default()
 
 	// Enable CORS for Flutter Web (Important for cross-domain calls)
+	// CORS_ALLOWED_ORIGINS is a comma-separated list, e.g. "https://dashboard.raptee.com"
 	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true // In production, replace with specific domain
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		config.AllowOrigins = strings.Split(origins, ",")
+	} else {
+		log.Println("Warning: CORS_ALLOWED_ORIGINS not set, allowing all origins")
+		config.AllowAllOrigins = true
+	}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key"}
 	r.Use(cors.New(config))
 
 	// 3. Endpoints
-	r.GET("/api/v1/analytics", handlers.HandleGetAnalytics) // Get Analytics
 	r.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
-	r.POST("/api/v1/sync", handlers.HandleSync)           // Write Ingestion
-	r.POST("/api/v1/provision", handlers.HandleProvision) // Provision/Update Bike
-	r.GET("/api/v1/bikes", handlers.HandleListBikes)      // List All Bikes
-	r.GET("/api/v1/telemetry", handlers.HandleRead)       // Read Pagination
-	r.DELETE("/api/v1/bikes", handlers.HandleDeleteBikes) // Delete Bikes (Bulk/Single)
-	r.DELETE("/api/v1/provision", handlers.HandleDeleteBike) // Delete Bike
-	r.DELETE("/api/v1/telemetry", handlers.HandleDeleteTelemetry) // Delete Telemetry (For Bulk/Single bikes )
-
-	// 4. Start Server (AWS App Runner defaults to Port 8080)
+
+	api := r.Group("/api/v1")
+
+	// Device endpoints (signed by the bike, see middleware.DeviceAuth)
+	api.POST("/sync", middleware.DeviceAuth(), handlers.HandleSync)                                        // Write Ingestion
+	api.POST("/provision", middleware.DeviceAuthOrRole(middleware.RoleOperator), handlers.HandleProvision) // Provision/Update Bike
+
+	// Dashboard reads (API key with reader role or above)
+	reader := api.Group("", middleware.RequireRole(middleware.RoleReader))
+	reader.GET("/analytics", handlers.HandleGetAnalytics)                                // Get Analytics
+	reader.GET("/analytics/fleet", handlers.HandleGetFleetAnalytics)                     // Fleet-Wide Analytics
+	reader.GET("/bikes", handlers.HandleListBikes)                                       // List All Bikes
+	reader.GET("/bikes/:bike_id/trips", handlers.HandleListTrips)                        // List Trips
+	reader.GET("/bikes/:bike_id/trips/:trip_id", handlers.HandleGetTrip)                 // Trip Detail + Telemetry
+	reader.GET("/bikes/:bike_id/geofence-events", handlers.HandleListBikeGeofenceEvents) // Bike Enter/Exit Events
+	reader.GET("/telemetry", handlers.HandleRead)                                        // Read Pagination
+	reader.GET("/telemetry/aggregate", handlers.HandleAggregate)                         // Time-Bucketed Stats
+	reader.GET("/telemetry/export", handlers.HandleExport)                               // GeoJSON / GPX Tracks
+	reader.GET("/geo/heatmap", handlers.HandleGeoHeatmap)                                // Map Grid Aggregates
+	reader.GET("/tiles/:z/:x/:y", handlers.HandleTile)                                   // Vector Tiles ({y}.mvt)
+	reader.GET("/schemas", handlers.HandleListSchemas)                                   // List Log Schemas
+	reader.GET("/schemas/:log_type", handlers.HandleGetSchema)
+	reader.GET("/geofences", handlers.HandleListGeofences)                    // List Geofences
+	reader.GET("/geofences/:fence_id", handlers.HandleGetGeofence)            // Get Geofence
+	reader.GET("/geofences/:fence_id/events", handlers.HandleListFenceEvents) // Fence Enter/Exit Events
+	reader.GET("/retention", handlers.HandleListRetention)                    // Retention Policies + Pruned Counts
+
+	// Configuration changes (API key with operator role or above)
+	operator := api.Group("", middleware.RequireRole(middleware.RoleOperator))
+	operator.POST("/schemas", handlers.HandleCreateSchema)                   // Register Log Type
+	operator.PUT("/schemas/:log_type/:version", handlers.HandleUpdateSchema) // Update Log Type Fields
+	operator.POST("/geofences", handlers.HandleCreateGeofence)               // Define Geofence
+	operator.PUT("/geofences/:fence_id", handlers.HandleUpdateGeofence)      // Replace Geofence
+
+	// Destructive operations (API key with admin role)
+	admin := api.Group("", middleware.RequireRole(middleware.RoleAdmin))
+	admin.DELETE("/bikes", handlers.HandleDeleteBikes)                       // Delete Bikes (Bulk/Single)
+	admin.DELETE("/provision", handlers.HandleDeleteBike)                    // Delete Bike
+	admin.DELETE("/telemetry", handlers.HandleDeleteTelemetry)               // Delete Telemetry (For Bulk/Single bikes )
+	admin.DELETE("/schemas/:log_type/:version", handlers.HandleDeleteSchema) // Unregister Log Type Version
+	admin.DELETE("/geofences/:fence_id", handlers.HandleDeleteGeofence)      // Delete Geofence + Events
+	admin.PUT("/retention/:log_type", handlers.HandleSetRetention)           // Set Log Type Retention
+	admin.DELETE("/retention/:log_type", handlers.HandleDeleteRetention)     // Keep Log Type Forever
+	admin.POST("/retention/run", handlers.HandleRunRetention)                // Prune Expired Rows Now
+
+	// 4. Start gRPC Server (TelemetryService.Sync, shares the HandleSync insert path)
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	lis, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatalf("Unable to listen on gRPC port %s: %v", grpcPort, err)
+	}
+	grpcServer := grpcserver.New()
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+
+	// 5. Start Server (AWS App Runner defaults to Port 8080)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	r.Run(":" + port)
-}
\ No newline at end of file
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	// 6. Graceful Shutdown: finish in-flight requests, then stop the workers
+	// before the pool closes
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
+	workers.Wait()
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/db"
+	"raptee-backend/utils"
+)
+
+// API key roles, from least to most privileged
+const (
+	RoleReader   = "reader"   // Dashboard reads
+	RoleOperator = "operator" // Configuration changes
+	RoleAdmin    = "admin"    // Destructive operations
+)
+
+var roleRank = map[string]int{RoleReader: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Gin context keys set by RequireRole
+const (
+	APIKeyIDKey   = "api_key_id"
+	APIKeyRoleKey = "api_key_role"
+)
+
+// lastUsedInterval is how stale api_keys.last_used_at may get before a
+// request refreshes it, so authenticating stays a read
+const lastUsedInterval = time.Minute
+
+// lastUsedTouched is when this instance last refreshed each key's
+// last_used_at, so a burst of requests triggers one UPDATE
+var lastUsedTouched sync.Map // key_id -> time.Time
+
+// IsValidRole reports whether role is one of the known API key roles
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RequireRole authenticates the API key sent as "Authorization: Bearer <key>"
+// or "X-API-Key: <key>" and requires at least the given role.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if auth := c.GetHeader("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			return
+		}
+
+		var keyID, keyRole string
+		var lastUsed *time.Time
+		err := db.Pool.QueryRow(c.Request.Context(), `
+			SELECT key_id, role, last_used_at FROM api_keys
+			WHERE key_hash = $1 AND revoked_at IS NULL`, utils.HashAPIKey(key)).Scan(&keyID, &keyRole, &lastUsed)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or revoked API key"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
+			return
+		}
+
+		if roleRank[keyRole] < roleRank[role] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires " + role + " role"})
+			return
+		}
+
+		if lastUsed == nil || time.Since(*lastUsed) > lastUsedInterval {
+			touchLastUsed(keyID)
+		}
+
+		c.Set(APIKeyIDKey, keyID)
+		c.Set(APIKeyRoleKey, keyRole)
+		c.Next()
+	}
+}
+
+// touchLastUsed refreshes a key's last_used_at in the background, at most once
+// per lastUsedInterval from this instance. Other instances racing it are
+// no-ops thanks to the WHERE clause.
+func touchLastUsed(keyID string) {
+	now := time.Now()
+	if prev, ok := lastUsedTouched.Load(keyID); ok && now.Sub(prev.(time.Time)) < lastUsedInterval {
+		return
+	}
+	lastUsedTouched.Store(keyID, now)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := db.Pool.Exec(ctx, `
+			UPDATE api_keys SET last_used_at = NOW()
+			WHERE key_id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - make_interval(secs => $2))`,
+			keyID, lastUsedInterval.Seconds())
+		if err != nil {
+			log.Printf("Could not update last_used_at for API key %s: %v", keyID, err)
+		}
+	}()
+}
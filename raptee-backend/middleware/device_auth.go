@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"raptee-backend/db"
+	"raptee-backend/utils"
+)
+
+// Request headers carrying the device signature
+const (
+	HeaderBikeID    = "X-Bike-ID"
+	HeaderTimestamp = "X-Timestamp" // Unix seconds
+	HeaderNonce     = "X-Nonce"     // Unique per request, 16-64 characters
+	HeaderSignature = "X-Signature" // See utils.SignRequest
+)
+
+// DeviceBikeIDKey is the gin context key holding the authenticated bike_id
+const DeviceBikeIDKey = "device_bike_id"
+
+// SignatureWindow is how far X-Timestamp may drift from server time.
+// Requests outside it are rejected as replays; inside it, a nonce seen before
+// for the bike is.
+const SignatureWindow = 5 * time.Minute
+
+// nonceTTL is how long used nonces are remembered: a timestamp stays valid
+// for SignatureWindow either side of now
+const nonceTTL = 2 * SignatureWindow
+
+// Upper bound on a signed (possibly compressed) request body
+const maxSignedBodyBytes = 32 << 20
+
+var (
+	ErrMissingSignature = errors.New("missing device signature headers")
+	ErrStaleTimestamp   = errors.New("timestamp outside allowed window")
+	ErrNoCredentials    = errors.New("bike is not provisioned with credentials")
+	ErrBadSignature     = errors.New("invalid signature")
+	ErrBadNonce         = errors.New("nonce must be 16-64 characters")
+	ErrReplayedNonce    = errors.New("nonce already used")
+)
+
+// SignedRequest is what a device signature covers, from HTTP headers or gRPC
+// metadata
+type SignedRequest struct {
+	BikeID    string
+	Method    string // HTTP method; POST for gRPC
+	Path      string // URL path; the full method name for gRPC
+	Timestamp string
+	Nonce     string
+	Signature string
+	Body      []byte // Exactly as sent
+}
+
+// DeviceAuth requires a valid device signature on every request
+func DeviceAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bikeID := c.GetHeader(HeaderBikeID)
+
+		// Read the raw body (exactly as signed) and put it back for the handler.
+		// One byte past the limit tells an oversized body from one at the limit.
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodyBytes+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Could not read body: " + err.Error()})
+			return
+		}
+		if len(body) > maxSignedBodyBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "body exceeds 32 MB"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = VerifyDeviceSignature(c.Request.Context(), SignedRequest{
+			BikeID:    bikeID,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Timestamp: c.GetHeader(HeaderTimestamp),
+			Nonce:     c.GetHeader(HeaderNonce),
+			Signature: c.GetHeader(HeaderSignature),
+			Body:      body,
+		})
+		if err != nil {
+			status := http.StatusUnauthorized
+			if !IsAuthError(err) {
+				status = http.StatusInternalServerError
+			}
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(DeviceBikeIDKey, bikeID)
+		c.Next()
+	}
+}
+
+// DeviceAuthOrRole verifies the device signature when one is sent, and
+// otherwise requires an API key with at least the given role (an operator
+// provisioning a bike that has no credentials yet).
+func DeviceAuthOrRole(role string) gin.HandlerFunc {
+	signed := DeviceAuth()
+	operator := RequireRole(role)
+	return func(c *gin.Context) {
+		if c.GetHeader(HeaderBikeID) == "" && c.GetHeader(HeaderSignature) == "" {
+			operator(c)
+			return
+		}
+		signed(c)
+	}
+}
+
+// VerifyDeviceSignature checks a signed request against the bike's sealed key
+// in the bikes table, then records its nonce so the request can't be replayed.
+// Shared by the HTTP middleware and gRPC interceptor.
+func VerifyDeviceSignature(ctx context.Context, req SignedRequest) error {
+	if req.BikeID == "" || req.Timestamp == "" || req.Nonce == "" || req.Signature == "" {
+		return ErrMissingSignature
+	}
+	if len(req.Nonce) < 16 || len(req.Nonce) > 64 {
+		return ErrBadNonce
+	}
+
+	ts, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	drift := time.Since(time.Unix(ts, 0))
+	if drift > SignatureWindow || drift < -SignatureWindow {
+		return ErrStaleTimestamp
+	}
+
+	var sealed []byte
+	err = db.Pool.QueryRow(ctx, "SELECT device_key_enc FROM bikes WHERE bike_id = $1", req.BikeID).Scan(&sealed)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && sealed == nil) {
+		return ErrNoCredentials
+	}
+	if err != nil {
+		return err
+	}
+	key, err := utils.OpenDeviceKey(req.BikeID, sealed)
+	if err != nil {
+		return err
+	}
+
+	expected := utils.SignRequest(key, req.Method, req.Path, ts, req.Nonce, req.Body)
+	if !hmac.Equal([]byte(expected), []byte(req.Signature)) {
+		return ErrBadSignature
+	}
+
+	// Only signed requests get this far, so nonces can't be flooded in. The
+	// bike's expired nonces are cleared first.
+	_, err = db.Pool.Exec(ctx, "DELETE FROM device_nonces WHERE bike_id = $1 AND seen_at < NOW() - make_interval(secs => $2)",
+		req.BikeID, nonceTTL.Seconds())
+	if err != nil {
+		return err
+	}
+	res, err := db.Pool.Exec(ctx, "INSERT INTO device_nonces (bike_id, nonce) VALUES ($1, $2) ON CONFLICT (bike_id, nonce) DO NOTHING",
+		req.BikeID, req.Nonce)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrReplayedNonce
+	}
+	return nil
+}
+
+// IsAuthError reports whether err is a rejection of the caller's credentials
+// (as opposed to an internal failure while checking them)
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrMissingSignature) || errors.Is(err, ErrStaleTimestamp) ||
+		errors.Is(err, ErrNoCredentials) || errors.Is(err, ErrBadSignature) ||
+		errors.Is(err, ErrBadNonce) || errors.Is(err, ErrReplayedNonce)
+}
@@ -1,19 +1,38 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // CompactRequest represents the structure for sync requests
 type CompactRequest struct {
-	BikeID    string          `json:"bike_id"`
-	Timestamp string          `json:"sync_timestamp"`
-	Columns   []string        `json:"columns"`
-	Data      [][]interface{} `json:"data"` // List of Lists of "Anything"
+	BikeID        string          `json:"bike_id"`
+	Timestamp     string          `json:"sync_timestamp"`
+	SchemaVersion int             `json:"schema_version,omitempty"` // Payload layout for the batch; a "schema_version" column overrides per row. Undeclared rows are matched by payload length
+	Columns       []string        `json:"columns"`
+	Data          [][]interface{} `json:"data"` // List of Lists of "Anything"
+}
+
+// SyncResponse reports the outcome of a sync batch row by row
+type SyncResponse struct {
+	Status     string         `json:"status"`     // "success" or "partial"
+	Accepted   int            `json:"accepted"`   // Rows newly written
+	Duplicates int            `json:"duplicates"` // Rows already stored (same bike_id + uuid)
+	Rejected   []RowRejection `json:"rejected"`   // Rows that failed validation
+}
+
+// RowRejection describes why a single row of CompactRequest.Data was dropped
+type RowRejection struct {
+	Index  int    `json:"index"` // Position in CompactRequest.Data
+	Reason string `json:"reason"`
 }
 
 // ProvisionRequest represents the structure for provision requests
 type ProvisionRequest struct {
-	BikeID   string                 `json:"bike_id"`
-	Metadata map[string]interface{} `json:"metadata"`
+	BikeID       string                 `json:"bike_id"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	RotateSecret bool                   `json:"rotate_secret"` // Signed requests only: mint a new device secret
 }
 
 // Bike represents a bike entity
@@ -33,3 +52,97 @@ type BikeListResponse struct {
 type DeleteRequest struct {
 	BikeIDs []string `json:"bike_ids"`
 }
+
+// LogSchema describes how compact payload arrays of a log type are expanded
+type LogSchema struct {
+	LogType string     `json:"log_type"`
+	Version int        `json:"version"`
+	Fields  []FieldDef `json:"fields"` // Payload array index -> field definition
+}
+
+// Field types supported in schema definitions
+const (
+	FieldInt    = "int"
+	FieldFloat  = "float"
+	FieldString = "string"
+	FieldBool   = "bool"
+	FieldEnum   = "enum"
+	FieldAny    = "any" // Untyped: stored as sent
+)
+
+// FieldDef is a typed payload field. In JSON a bare string is accepted as
+// shorthand for an optional field of type "any", and a missing type means "any".
+type FieldDef struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Values   []string `json:"values,omitempty"` // Allowed values for enum
+}
+
+func (f *FieldDef) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*f = FieldDef{Name: name, Type: FieldAny}
+		return nil
+	}
+	type plain FieldDef
+	if err := json.Unmarshal(b, (*plain)(f)); err != nil {
+		return err
+	}
+	if f.Type == "" {
+		f.Type = FieldAny
+	}
+	return nil
+}
+
+// UntypedFields builds "any" field definitions from bare field names
+func UntypedFields(names []string) []FieldDef {
+	defs := make([]FieldDef, len(names))
+	for i, n := range names {
+		defs[i] = FieldDef{Name: n, Type: FieldAny}
+	}
+	return defs
+}
+
+// FieldNames returns the ordered names of field definitions
+func FieldNames(defs []FieldDef) []string {
+	names := make([]string, len(defs))
+	for i, d := range defs {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// Geofence is a named area whose boundary crossings are recorded as events
+type Geofence struct {
+	FenceID   int64           `json:"fence_id"`
+	Name      string          `json:"name"`
+	Kind      string          `json:"kind,omitempty"` // e.g. service_centre, test_track, charging_hub
+	Geometry  json.RawMessage `json:"geometry"`       // GeoJSON Polygon or MultiPolygon (WGS84)
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// GeofenceEvent records a bike entering or leaving a geofence
+type GeofenceEvent struct {
+	EventID    int64     `json:"event_id"`
+	FenceID    int64     `json:"fence_id"`
+	FenceName  string    `json:"fence_name"`
+	BikeID     string    `json:"bike_id"`
+	Event      string    `json:"event"`       // "enter" or "exit"
+	OccurredAt time.Time `json:"occurred_at"` // logged_at of the first row on the new side
+	LogID      string    `json:"log_id"`      // That row
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+}
+
+// RetentionPolicy is how long telemetry of a log type is kept, with the
+// pruning worker's counters
+type RetentionPolicy struct {
+	LogType       string     `json:"log_type"`
+	RetainDays    int        `json:"retain_days"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	LastRunAt     *time.Time `json:"last_run_at"`     // Last pruning pass (null = not yet)
+	LastRunPruned int64      `json:"last_run_pruned"` // Rows deleted by that pass
+	TotalPruned   int64      `json:"total_pruned"`    // Rows deleted since the policy was created
+}
@@ -0,0 +1,6 @@
+-- Register GPS_QUALITY so sync validation accepts it as a known log type.
+-- Field order matches the compact array the firmware already sends:
+-- [4, "Great", 10, 7250.43]
+INSERT INTO log_schemas (log_type, fields) VALUES
+('GPS_QUALITY', ARRAY['quality_value', 'quality', 'satellites', 'accuracy'])
+ON CONFLICT (log_type) DO NOTHING;
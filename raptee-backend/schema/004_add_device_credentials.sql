@@ -0,0 +1,7 @@
+-- Per-bike device credentials for signed sync/provision requests.
+-- The server never stores the minted secret. device_key_hash held the HMAC
+-- key derived from it in plaintext; it is superseded by the sealed
+-- device_key_enc (019) and cleared on startup.
+ALTER TABLE bikes
+ADD COLUMN IF NOT EXISTS device_key_hash TEXT,
+ADD COLUMN IF NOT EXISTS credentials_issued_at TIMESTAMPTZ;
@@ -0,0 +1,12 @@
+-- API keys for the dashboard and admin tooling.
+-- Roles are hierarchical: admin > operator > reader.
+-- Only the SHA-256 of each key is stored; manage keys with cmd/apikey.
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id TEXT PRIMARY KEY,                -- Public prefix, e.g. "rk_3f9a1c2b"
+    key_hash TEXT NOT NULL UNIQUE,          -- SHA-256 of the full key
+    name TEXT NOT NULL,                     -- Who/what the key is for
+    role TEXT NOT NULL CHECK (role IN ('reader', 'operator', 'admin')),
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ                  -- Set on revoke; revoked keys are rejected
+);
@@ -0,0 +1,13 @@
+-- Broadcast log_schemas changes so every API instance reloads its in-memory
+-- copy (db.ListenForSchemaChanges). Fires for API writes and manual SQL alike.
+CREATE OR REPLACE FUNCTION notify_log_schemas_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('log_schemas_changed', COALESCE(NEW.log_type, OLD.log_type));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS log_schemas_changed ON log_schemas;
+CREATE TRIGGER log_schemas_changed
+AFTER INSERT OR UPDATE OR DELETE ON log_schemas
+FOR EACH ROW EXECUTE FUNCTION notify_log_schemas_changed();
@@ -0,0 +1,15 @@
+-- 1. Key schemas by (log_type, version) so field order can evolve.
+--    Existing definitions become version 1 (what old firmware sends).
+ALTER TABLE log_schemas ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE log_schemas DROP CONSTRAINT IF EXISTS log_schemas_pkey;
+ALTER TABLE log_schemas ADD CONSTRAINT log_schemas_pkey PRIMARY KEY (log_type, version);
+
+-- 2. API_LATENCY v2: the 9-element array newer firmware sends
+--    ["https://...", "success", 200, "", 0, "unknown", "WiFi", "charging_station", 0]
+INSERT INTO log_schemas (log_type, version, fields) VALUES
+('API_LATENCY', 2, ARRAY['api_url', 'status', 'status_code', 'error_message', 'signal_strength', 'connection_state', 'network_type', 'api_call', 'retry_count'])
+ON CONFLICT (log_type, version) DO NOTHING;
+
+-- 3. Record which schema version expanded each row.
+--    NULL = stored before versioning (expanded with version 1).
+ALTER TABLE telemetry_logs ADD COLUMN IF NOT EXISTS schema_version INTEGER;
@@ -0,0 +1,40 @@
+-- Typed field definitions for log schemas.
+-- field_defs is an ordered JSON array of {"name", "type", "required", "values"}:
+--   type: int | float | string | bool | enum | any   (values = allowed enum values)
+-- `fields` keeps the ordered names. NULL field_defs = untyped ("any") fields.
+ALTER TABLE log_schemas ADD COLUMN IF NOT EXISTS field_defs JSONB;
+
+UPDATE log_schemas SET field_defs = '[
+    {"name": "api_call",         "type": "string", "required": true},
+    {"name": "status",           "type": "string", "required": true},
+    {"name": "status_code",      "type": "int",    "required": true},
+    {"name": "error_message",    "type": "string", "required": false},
+    {"name": "signal_strength",  "type": "int",    "required": false},
+    {"name": "connection_state", "type": "string", "required": false},
+    {"name": "network_type",     "type": "string", "required": false}
+]' WHERE log_type = 'API_LATENCY' AND version = 1;
+
+UPDATE log_schemas SET field_defs = '[
+    {"name": "api_url",          "type": "string", "required": true},
+    {"name": "status",           "type": "string", "required": true},
+    {"name": "status_code",      "type": "int",    "required": true},
+    {"name": "error_message",    "type": "string", "required": false},
+    {"name": "signal_strength",  "type": "int",    "required": false},
+    {"name": "connection_state", "type": "string", "required": false},
+    {"name": "network_type",     "type": "string", "required": false},
+    {"name": "api_call",         "type": "string", "required": true},
+    {"name": "retry_count",      "type": "int",    "required": false}
+]' WHERE log_type = 'API_LATENCY' AND version = 2;
+
+UPDATE log_schemas SET field_defs = '[
+    {"name": "anomaly",       "type": "string", "required": true},
+    {"name": "description",   "type": "string", "required": false},
+    {"name": "jump_distance", "type": "float",  "required": true}
+]' WHERE log_type = 'GPS_ANOMALY' AND version = 1;
+
+UPDATE log_schemas SET field_defs = '[
+    {"name": "quality_value", "type": "int",    "required": true},
+    {"name": "quality",       "type": "string", "required": false},
+    {"name": "satellites",    "type": "int",    "required": false},
+    {"name": "accuracy",      "type": "float",  "required": false}
+]' WHERE log_type = 'GPS_QUALITY' AND version = 1;
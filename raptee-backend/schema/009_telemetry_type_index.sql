@@ -0,0 +1,5 @@
+-- Seek index for GET /api/v1/telemetry filtered by log type
+-- (e.g. "GPS_ANOMALY for bike X between 10:00 and 11:00").
+-- Unfiltered and time-range-only reads keep using idx_telemetry_seek.
+CREATE INDEX IF NOT EXISTS idx_telemetry_type_seek
+    ON telemetry_logs (bike_id, log_type, logged_at DESC, log_id DESC);
@@ -0,0 +1,29 @@
+-- Hourly and daily API_LATENCY aggregates, maintained by the sync handler in
+-- the same transaction as the raw insert. Fleet analytics reads these instead
+-- of telemetry_logs when its window is aligned to the buckets.
+-- latency_sketch is a sketch.Histogram (successful calls only) in its binary
+-- encoding; counts are exact.
+-- Existing telemetry can be backfilled with: go run cmd/rollups/main.go
+CREATE TABLE IF NOT EXISTS telemetry_rollups (
+    resolution TEXT NOT NULL CHECK (resolution IN ('hour', 'day')),
+    bucket TIMESTAMPTZ NOT NULL,          -- Bucket start (UTC)
+    bike_id TEXT NOT NULL REFERENCES bikes(bike_id) ON DELETE CASCADE,
+    log_type TEXT NOT NULL,
+    api_call TEXT NOT NULL,
+    connection_state TEXT NOT NULL,
+
+    total_calls BIGINT NOT NULL DEFAULT 0,
+    success_calls BIGINT NOT NULL DEFAULT 0,
+    network_errors BIGINT NOT NULL DEFAULT 0,
+    server_errors BIGINT NOT NULL DEFAULT 0,
+    client_errors BIGINT NOT NULL DEFAULT 0,
+    first_at TIMESTAMPTZ,
+    last_at TIMESTAMPTZ,
+    latency_sketch BYTEA,
+
+    PRIMARY KEY (resolution, bucket, bike_id, log_type, api_call, connection_state)
+);
+
+-- Per-bike rebuilds/deletes and fleet reads over a bike subset
+CREATE INDEX IF NOT EXISTS idx_rollups_bike
+    ON telemetry_rollups (bike_id, resolution, bucket);
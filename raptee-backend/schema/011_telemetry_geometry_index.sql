@@ -0,0 +1,7 @@
+-- Planar (lng/lat) index for the map endpoints. They select points by a
+-- lng/lat bounding box, which is a plain rectangle in geometry but not in
+-- geography (whose box edges are great circles), so they filter on
+-- location::geometry and need this expression index rather than
+-- idx_telemetry_geo.
+CREATE INDEX IF NOT EXISTS idx_telemetry_geom
+    ON telemetry_logs USING GIST ((location::geometry));
@@ -0,0 +1,19 @@
+-- Rides reconstructed from located telemetry (see handlers/trips.go).
+-- Maintained by the sync handler; rebuild with: go run cmd/trips/main.go
+-- trip_id is derived from (bike_id, started_at), so it is stable as long as
+-- the trip's first point doesn't change.
+CREATE TABLE IF NOT EXISTS trips (
+    trip_id UUID PRIMARY KEY,
+    bike_id TEXT NOT NULL REFERENCES bikes(bike_id) ON DELETE CASCADE,
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ NOT NULL,
+    start_point GEOGRAPHY(POINT, 4326) NOT NULL,
+    end_point GEOGRAPHY(POINT, 4326) NOT NULL,
+    distance_m DOUBLE PRECISION NOT NULL,  -- Sum of point-to-point distances
+    point_count INTEGER NOT NULL,
+    path GEOGRAPHY(LINESTRING, 4326) NOT NULL
+);
+
+-- Listing a bike's trips (newest first) and finding trips around a sync window
+CREATE INDEX IF NOT EXISTS idx_trips_bike_time
+    ON trips (bike_id, started_at DESC);
@@ -0,0 +1,27 @@
+-- Named areas (service centres, test tracks, charging hubs) and the
+-- enter/exit events detected when a bike's consecutive located rows cross
+-- their boundary. Events are maintained by the sync handler.
+CREATE TABLE IF NOT EXISTS geofences (
+    fence_id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    kind TEXT,                                  -- Free-form category, e.g. "service_centre"
+    geom GEOMETRY(MULTIPOLYGON, 4326) NOT NULL, -- Planar lng/lat, like the map endpoints
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_geofences_geom ON geofences USING GIST (geom);
+
+CREATE TABLE IF NOT EXISTS geofence_events (
+    event_id BIGSERIAL PRIMARY KEY,
+    fence_id BIGINT NOT NULL REFERENCES geofences(fence_id) ON DELETE CASCADE,
+    bike_id TEXT NOT NULL REFERENCES bikes(bike_id) ON DELETE CASCADE,
+    event TEXT NOT NULL CHECK (event IN ('enter', 'exit')),
+    occurred_at TIMESTAMPTZ NOT NULL,           -- logged_at of the first row on the new side
+    log_id UUID NOT NULL,                       -- That row
+    location GEOGRAPHY(POINT, 4326) NOT NULL
+);
+
+-- Per-bike and per-fence event history (and re-detection around a sync window)
+CREATE INDEX IF NOT EXISTS idx_geofence_events_bike ON geofence_events (bike_id, occurred_at DESC);
+CREATE INDEX IF NOT EXISTS idx_geofence_events_fence ON geofence_events (fence_id, occurred_at DESC);
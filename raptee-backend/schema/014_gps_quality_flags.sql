@@ -0,0 +1,11 @@
+-- Server-side GPS quality check (see handlers/gps.go). Sync flags every
+-- located row against the bike's previous good fix:
+--   ok             plausible position
+--   null_island    exactly (0,0), i.e. synced without a fix
+--   out_of_region  outside the operating region (GPS_REGION_BBOX)
+--   jump           implied speed from the previous good fix is impossible
+-- NULL means the row has no location or predates the check; recompute with:
+-- go run cmd/gps-quality/main.go
+ALTER TABLE telemetry_logs ADD COLUMN IF NOT EXISTS gps_flag TEXT
+    CHECK (gps_flag IN ('ok', 'null_island', 'out_of_region', 'jump'));
+
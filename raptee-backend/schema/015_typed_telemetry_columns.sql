@@ -0,0 +1,10 @@
+-- Optional typed values a row may carry next to val_primary, so they can be
+-- filtered, aggregated and indexed without digging into payload. Sync fills
+-- them from compact columns of the same name; NULL when not sent.
+ALTER TABLE telemetry_logs
+    ADD COLUMN IF NOT EXISTS val_secondary DOUBLE PRECISION, -- Second value, meaning depends on log_type
+    ADD COLUMN IF NOT EXISTS speed DOUBLE PRECISION,         -- km/h
+    ADD COLUMN IF NOT EXISTS altitude DOUBLE PRECISION,      -- Meters above sea level
+    ADD COLUMN IF NOT EXISTS heading DOUBLE PRECISION,       -- Degrees clockwise from north, [0, 360]
+    ADD COLUMN IF NOT EXISTS accuracy DOUBLE PRECISION,      -- GPS horizontal accuracy, meters
+    ADD COLUMN IF NOT EXISTS battery_pct DOUBLE PRECISION;   -- State of charge, [0, 100]
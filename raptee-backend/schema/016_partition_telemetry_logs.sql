@@ -0,0 +1,114 @@
+-- Range-partitions telemetry_logs by month on logged_at (UTC), so old data is
+-- dropped a partition at a time instead of deleted row by row, and vacuum and
+-- index maintenance work on one month at a time.
+--
+-- Partitions are named telemetry_logs_pYYYYMM. Rows whose month has no
+-- partition yet (e.g. a bike with a wrong clock) land in
+-- telemetry_logs_default; create_telemetry_partition moves them out when it
+-- creates their month. The server keeps partitions ahead of time and drops
+-- expired ones (see db/partitions.go, cmd/partitions).
+--
+-- Unique indexes on a partitioned table must include logged_at, so the
+-- database only enforces (bike_id, logged_at, log_id) (idx_telemetry_seek).
+-- Sync keeps a log_id unique per bike by skipping rows whose log_id is
+-- already stored (idx_telemetry_log_id), under the bike's row lock, so a
+-- resend with a changed timestamp is still a duplicate.
+--
+-- This migration only swaps the tables: existing rows stay in
+-- telemetry_logs_unpartitioned until `go run cmd/partitions/main.go -backfill`
+-- moves them bike by bike in small transactions and drops it. Until then they
+-- are missing from reads. Its indexes, other than the one the backfill walks,
+-- are dropped so the new table can take their names.
+
+ALTER TABLE telemetry_logs RENAME TO telemetry_logs_unpartitioned;
+ALTER INDEX idx_telemetry_seek RENAME TO idx_telemetry_unpartitioned_seek;
+DROP INDEX IF EXISTS idx_telemetry_type_seek;
+DROP INDEX IF EXISTS idx_telemetry_geo;
+DROP INDEX IF EXISTS idx_telemetry_geom;
+
+CREATE TABLE telemetry_logs (
+    log_id UUID NOT NULL,
+    bike_id TEXT NOT NULL REFERENCES bikes(bike_id) ON DELETE CASCADE,
+    logged_at TIMESTAMPTZ NOT NULL,
+    log_type TEXT NOT NULL,
+    val_primary INTEGER,
+    location GEOGRAPHY(POINT, 4326),
+    payload JSONB,
+    schema_version INTEGER,
+    gps_flag TEXT CHECK (gps_flag IN ('ok', 'null_island', 'out_of_region', 'jump')),
+    val_secondary DOUBLE PRECISION,
+    speed DOUBLE PRECISION,
+    altitude DOUBLE PRECISION,
+    heading DOUBLE PRECISION,
+    accuracy DOUBLE PRECISION,
+    battery_pct DOUBLE PRECISION
+) PARTITION BY RANGE (logged_at);
+
+CREATE TABLE telemetry_logs_default PARTITION OF telemetry_logs DEFAULT;
+
+-- Creates the partition for the UTC month containing month_start, moving any
+-- rows of that month out of telemetry_logs_default first (a partition can't
+-- be attached while the default one holds rows in its range). Returns the
+-- partition name, or NULL if it already exists.
+CREATE OR REPLACE FUNCTION create_telemetry_partition(month_start TIMESTAMPTZ) RETURNS TEXT AS $$
+DECLARE
+    lo TIMESTAMP := date_trunc('month', month_start AT TIME ZONE 'UTC');
+    hi TIMESTAMP := lo + INTERVAL '1 month';
+    part TEXT := 'telemetry_logs_p' || to_char(lo, 'YYYYMM');
+BEGIN
+    IF to_regclass(part) IS NOT NULL THEN
+        RETURN NULL;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I (LIKE telemetry_logs INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', part);
+    EXECUTE format('WITH moved AS (DELETE FROM telemetry_logs_default WHERE logged_at >= %L AND logged_at < %L RETURNING *)
+        INSERT INTO %I SELECT * FROM moved', lo AT TIME ZONE 'UTC', hi AT TIME ZONE 'UTC', part);
+    EXECUTE format('ALTER TABLE telemetry_logs ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        part, lo AT TIME ZONE 'UTC', hi AT TIME ZONE 'UTC');
+    RETURN part;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Detaches and drops every monthly partition that ends on or before cutoff.
+-- Returns the dropped partition names.
+CREATE OR REPLACE FUNCTION drop_telemetry_partitions(cutoff TIMESTAMPTZ) RETURNS SETOF TEXT AS $$
+DECLARE
+    part TEXT;
+BEGIN
+    FOR part IN
+        SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'telemetry_logs'::regclass AND c.relname ~ '^telemetry_logs_p[0-9]{6}$'
+        ORDER BY c.relname
+    LOOP
+        IF (to_date(substr(part, 17), 'YYYYMM')::timestamp + INTERVAL '1 month') AT TIME ZONE 'UTC' <= cutoff THEN
+            EXECUTE format('ALTER TABLE telemetry_logs DETACH PARTITION %I', part);
+            EXECUTE format('DROP TABLE %I', part);
+            RETURN NEXT part;
+        END IF;
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+-- One partition per month that has data (so the backfill never lands in the
+-- default partition), plus this month and the next three
+SELECT create_telemetry_partition(m)
+FROM (
+    SELECT DISTINCT date_trunc('month', logged_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS m
+    FROM telemetry_logs_unpartitioned
+    UNION
+    SELECT (date_trunc('month', NOW() AT TIME ZONE 'UTC') + n * INTERVAL '1 month') AT TIME ZONE 'UTC'
+    FROM generate_series(0, 3) AS n
+) months
+ORDER BY m;
+
+-- Indexes cascade to every partition, including ones created later
+CREATE UNIQUE INDEX idx_telemetry_seek
+    ON telemetry_logs (bike_id, logged_at DESC, log_id DESC);
+CREATE INDEX idx_telemetry_log_id
+    ON telemetry_logs (bike_id, log_id);
+CREATE INDEX idx_telemetry_type_seek
+    ON telemetry_logs (bike_id, log_type, logged_at DESC, log_id DESC);
+CREATE INDEX idx_telemetry_geo
+    ON telemetry_logs USING GIST (location);
+CREATE INDEX idx_telemetry_geom
+    ON telemetry_logs USING GIST ((location::geometry));
@@ -0,0 +1,12 @@
+-- Per log type retention (see db/retention.go). Every hour the server deletes
+-- rows of each log_type older than retain_days, in bounded batches. Log types
+-- without a policy are kept forever. The last_run_*/total_pruned columns are
+-- the worker's counters, served by GET /api/v1/retention.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    log_type TEXT PRIMARY KEY,
+    retain_days INTEGER NOT NULL CHECK (retain_days > 0),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_run_at TIMESTAMPTZ,
+    last_run_pruned BIGINT NOT NULL DEFAULT 0,
+    total_pruned BIGINT NOT NULL DEFAULT 0
+);
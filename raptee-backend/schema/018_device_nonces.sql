@@ -0,0 +1,12 @@
+-- Nonces of signed device requests (see middleware.VerifyDeviceSignature).
+-- A (bike_id, nonce) seen within the signature window is a replay. Rows older
+-- than twice the window are deleted as the bike sends new requests.
+CREATE TABLE IF NOT EXISTS device_nonces (
+    bike_id TEXT NOT NULL REFERENCES bikes(bike_id) ON DELETE CASCADE,
+    nonce TEXT NOT NULL,
+    seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (bike_id, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_nonces_seen
+    ON device_nonces (bike_id, seen_at);
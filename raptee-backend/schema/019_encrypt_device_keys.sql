@@ -0,0 +1,7 @@
+-- Device HMAC keys are stored sealed with a server-side key
+-- (DEVICE_KEY_ENCRYPTION_KEY, AES-256-GCM, bike_id authenticated), so a
+-- database read or backup alone can't sign as a bike.
+-- On startup the server seals any plaintext device_key_hash left over from 004
+-- into device_key_enc and clears it.
+ALTER TABLE bikes
+ADD COLUMN IF NOT EXISTS device_key_enc BYTEA;
@@ -0,0 +1,9 @@
+-- How far back raw telemetry has been deleted, per log type ('*' for every
+-- type, when expired partitions are dropped). Rollups are kept when raw rows
+-- go and keep serving aligned analytics windows; raw analytics reads start at
+-- this cutoff and report it as raw_from. Rows outlive their retention policy:
+-- deleting the policy doesn't bring the data back.
+CREATE TABLE IF NOT EXISTS telemetry_pruned (
+    log_type TEXT PRIMARY KEY,
+    pruned_before TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,243 @@
+// Package sketch provides a mergeable latency histogram for computing
+// percentiles in bounded memory.
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// RelativeAccuracy bounds the error of Quantile: the result is within 1% of
+// the exact nearest-rank value, give or take rounding to an integer (so it is
+// exact for values below 50).
+const RelativeAccuracy = 0.01
+
+var (
+	gamma    = (1 + RelativeAccuracy) / (1 - RelativeAccuracy)
+	logGamma = math.Log(gamma)
+)
+
+// Histogram counts integer values (latencies in ms) in logarithmic buckets,
+// DDSketch style: bucket i holds values in (gamma^(i-1), gamma^i]. Values <= 0
+// share one bucket. Count, Sum, Min and Max are exact.
+//
+// Memory depends on the value range, not the count: 1ms..1h is ~750 buckets.
+// Histograms merge losslessly, so per-hour sketches can be combined into any
+// larger window.
+type Histogram struct {
+	counts []uint64 // counts[j] is bucket offset+j
+	offset int
+	zero   uint64 // Values <= 0
+	count  uint64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// New returns an empty histogram
+func New() *Histogram {
+	return &Histogram{}
+}
+
+func bucketIndex(v int64) int {
+	return int(math.Ceil(math.Log(float64(v)) / logGamma))
+}
+
+// Add records one value
+func (h *Histogram) Add(v int) {
+	h.addN(int64(v), 1)
+}
+
+func (h *Histogram) addN(v int64, n uint64) {
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count += n
+	h.sum += v * int64(n)
+
+	if v <= 0 {
+		h.zero += n
+		return
+	}
+	h.addToBucket(bucketIndex(v), n)
+}
+
+func (h *Histogram) addToBucket(i int, n uint64) {
+	if len(h.counts) == 0 {
+		h.counts = []uint64{0}
+		h.offset = i
+	}
+	if i < h.offset {
+		grown := make([]uint64, len(h.counts)+h.offset-i)
+		copy(grown[h.offset-i:], h.counts)
+		h.counts, h.offset = grown, i
+	}
+	if j := i - h.offset; j >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, j-len(h.counts)+1)...)
+	}
+	h.counts[i-h.offset] += n
+}
+
+// Merge adds every value recorded in o to h
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if h.count == 0 || o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.zero += o.zero
+	for j, n := range o.counts {
+		if n > 0 {
+			h.addToBucket(o.offset+j, n)
+		}
+	}
+}
+
+// Count returns the number of values recorded
+func (h *Histogram) Count() int { return int(h.count) }
+
+// Sum returns the exact sum of the values recorded
+func (h *Histogram) Sum() int64 { return h.sum }
+
+// Min returns the smallest value recorded (0 if empty)
+func (h *Histogram) Min() int { return int(h.min) }
+
+// Max returns the largest value recorded (0 if empty)
+func (h *Histogram) Max() int { return int(h.max) }
+
+// Mean returns the exact mean (0 if empty)
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.count)
+}
+
+// Quantile returns the nearest-rank q-quantile (0 < q <= 1), i.e. the value at
+// rank ceil(q*count), within RelativeAccuracy. It returns 0 if empty.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(float64(h.count) * q))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > h.count {
+		rank = h.count
+	}
+
+	if rank <= h.zero {
+		return float64(min(h.max, 0))
+	}
+	seen := h.zero
+	for j, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			// Midpoint of the bucket in relative terms, rounded since the
+			// inputs are integers, and never outside the observed range
+			v := math.Round(2 * math.Pow(gamma, float64(h.offset+j)) / (gamma + 1))
+			return math.Max(float64(h.min), math.Min(float64(h.max), v))
+		}
+	}
+	return float64(h.max)
+}
+
+// Binary layout (all varints): version, count, sum, min, max, zero, offset,
+// number of buckets, bucket counts.
+const encodingVersion = 1
+
+// MarshalBinary encodes the histogram for storage (e.g. in a BYTEA column)
+func (h *Histogram) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 8*binary.MaxVarintLen64+len(h.counts)*2)
+	buf = binary.AppendUvarint(buf, encodingVersion)
+	buf = binary.AppendUvarint(buf, h.count)
+	buf = binary.AppendVarint(buf, h.sum)
+	buf = binary.AppendVarint(buf, h.min)
+	buf = binary.AppendVarint(buf, h.max)
+	buf = binary.AppendUvarint(buf, h.zero)
+	buf = binary.AppendVarint(buf, int64(h.offset))
+	buf = binary.AppendUvarint(buf, uint64(len(h.counts)))
+	for _, n := range h.counts {
+		buf = binary.AppendUvarint(buf, n)
+	}
+	return buf, nil
+}
+
+var errCorrupt = errors.New("sketch: corrupt histogram encoding")
+
+// UnmarshalBinary decodes a histogram written by MarshalBinary
+func (h *Histogram) UnmarshalBinary(data []byte) error {
+	r := &reader{buf: data}
+	if v := r.uvarint(); v != encodingVersion {
+		if r.err != nil {
+			return r.err
+		}
+		return errors.New("sketch: unsupported histogram encoding version")
+	}
+
+	var out Histogram
+	out.count = r.uvarint()
+	out.sum = r.varint()
+	out.min = r.varint()
+	out.max = r.varint()
+	out.zero = r.uvarint()
+	out.offset = int(r.varint())
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.buf)) {
+		return errCorrupt
+	}
+	if n > 0 {
+		out.counts = make([]uint64, n)
+		for j := range out.counts {
+			out.counts[j] = r.uvarint()
+		}
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		return errCorrupt
+	}
+
+	*h = out
+	return nil
+}
+
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errCorrupt
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errCorrupt
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
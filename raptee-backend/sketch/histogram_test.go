@@ -0,0 +1,130 @@
+package sketch
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// getPercentile is the exact nearest-rank percentile the analytics handler
+// computed by sorting every latency, before the sketch replaced it
+func getPercentile(sorted []int, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(float64(len(sorted))*p)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return float64(sorted[idx])
+}
+
+var distributions = []struct {
+	name string
+	gen  func(rng *rand.Rand) int
+}{
+	{"uniform 100-5000ms", func(rng *rand.Rand) int { return 100 + rng.Intn(4900) }},
+	{"lognormal (median 400ms)", func(rng *rand.Rand) int { return int(math.Exp(6 + rng.NormFloat64())) }},
+	{"bimodal wifi/cellular", func(rng *rand.Rand) int {
+		if rng.Float64() < 0.7 {
+			return int(150 + 30*rng.NormFloat64())
+		}
+		return int(2500 + 800*rng.NormFloat64())
+	}},
+	{"heavy tail (timeouts)", func(rng *rand.Rand) int {
+		if rng.Float64() < 0.02 {
+			return 30000 + rng.Intn(30000)
+		}
+		return 50 + rng.Intn(500)
+	}},
+	{"small ints 0-40ms", func(rng *rand.Rand) int { return rng.Intn(41) }},
+	{"constant 200ms", func(rng *rand.Rand) int { return 200 }},
+}
+
+var quantiles = []float64{0.50, 0.90, 0.95, 0.99}
+
+// Percentiles must stay within RelativeAccuracy of the exact nearest rank
+func TestQuantileAccuracy(t *testing.T) {
+	for _, d := range distributions {
+		t.Run(d.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			values := make([]int, 100000)
+			h := New()
+			for i := range values {
+				values[i] = d.gen(rng)
+				h.Add(values[i])
+			}
+			sort.Ints(values)
+
+			for _, q := range quantiles {
+				exact, approx := getPercentile(values, q), h.Quantile(q)
+				if math.Abs(approx-exact) > exact*RelativeAccuracy+0.5 {
+					t.Errorf("p%.0f = %.0f, exact %.0f (outside 1%%)", q*100, approx, exact)
+				}
+			}
+			if h.Count() != len(values) || h.Min() != values[0] || h.Max() != values[len(values)-1] {
+				t.Errorf("count/min/max = %d/%d/%d, want %d/%d/%d",
+					h.Count(), h.Min(), h.Max(), len(values), values[0], values[len(values)-1])
+			}
+		})
+	}
+}
+
+// Per-hour sketches merged through their stored encoding must be identical to
+// one sketch of the whole window
+func TestMergeMatchesSingleSketch(t *testing.T) {
+	for _, d := range distributions {
+		t.Run(d.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(2))
+			whole := New()
+			hourly := make([]*Histogram, 24)
+			for i := range hourly {
+				hourly[i] = New()
+			}
+			for i := 0; i < 50000; i++ {
+				v := d.gen(rng)
+				whole.Add(v)
+				hourly[i%24].Add(v)
+			}
+
+			merged := New()
+			for _, h := range hourly {
+				b, err := h.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var decoded Histogram
+				if err := decoded.UnmarshalBinary(b); err != nil {
+					t.Fatalf("decode failed: %v", err)
+				}
+				merged.Merge(&decoded)
+			}
+
+			a, _ := merged.MarshalBinary()
+			b, _ := whole.MarshalBinary()
+			if !bytes.Equal(a, b) {
+				t.Errorf("merged sketch encodes differently from the single sketch")
+			}
+		})
+	}
+}
+
+func TestEmptyAndCorrupt(t *testing.T) {
+	h := New()
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 || h.Count() != 0 {
+		t.Errorf("empty histogram should report zeros")
+	}
+	b, _ := h.MarshalBinary()
+	var decoded Histogram
+	if err := decoded.UnmarshalBinary(b); err != nil || decoded.Count() != 0 {
+		t.Errorf("empty round trip: %v", err)
+	}
+	if err := decoded.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Errorf("truncated encoding should fail to decode")
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// NewAPIKey generates a dashboard/admin API key.
+// keyID is a short public identifier (safe to log and list);
+// key is the full secret handed to the caller once: "<keyID>_<secret>".
+func NewAPIKey() (keyID, key string, err error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 24)
+	if _, err = rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	keyID = "rk_" + hex.EncodeToString(id)
+	return keyID, keyID + "_" + hex.EncodeToString(secret), nil
+}
+
+// HashAPIKey returns the SHA-256 (hex) stored in api_keys.key_hash
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
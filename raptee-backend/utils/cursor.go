@@ -2,34 +2,46 @@ package utils
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
-// EncodeCursor creates a base64 encoded cursor string
-func EncodeCursor(t time.Time, uuid string) string {
-	// Combine timestamp and uuid with a separator, then Base64 encode
-	raw := fmt.Sprintf("%s|%s", t.Format(time.RFC3339Nano), uuid)
+// ErrInvalidCursor is returned for cursors that don't decode
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor creates a base64 encoded cursor string.
+// filterKey identifies the query the cursor belongs to (see DecodeCursor).
+func EncodeCursor(t time.Time, logID, filterKey string) string {
+	// Combine timestamp, uuid and filter key with a separator, then Base64 encode
+	raw := fmt.Sprintf("%s|%s|%s", t.Format(time.RFC3339Nano), logID, filterKey)
 	return base64.StdEncoding.EncodeToString([]byte(raw))
 }
 
-// DecodeCursor parses a base64 encoded cursor string
-func DecodeCursor(c string) (time.Time, string) {
+// DecodeCursor parses a base64 encoded cursor string. The caller must check the
+// returned filter key against its own query, so a page can't be resumed with
+// different filters.
+func DecodeCursor(c string) (time.Time, string, string, error) {
 	b, err := base64.StdEncoding.DecodeString(c)
 	if err != nil {
-		return time.Time{}, ""
+		return time.Time{}, "", "", ErrInvalidCursor
 	}
 
 	parts := strings.Split(string(b), "|")
-	if len(parts) != 2 {
-		return time.Time{}, ""
+	if len(parts) != 3 {
+		return time.Time{}, "", "", ErrInvalidCursor
 	}
 
 	t, err := time.Parse(time.RFC3339Nano, parts[0])
 	if err != nil {
-		return time.Time{}, ""
+		return time.Time{}, "", "", ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(parts[1]); err != nil {
+		return time.Time{}, "", "", ErrInvalidCursor
 	}
 
-	return t, parts[1]
+	return t, parts[1], parts[2], nil
 }
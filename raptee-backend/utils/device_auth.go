@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+// deviceKeyAEAD encrypts device HMAC keys at rest; set by InitDeviceKeyCipher
+var deviceKeyAEAD cipher.AEAD
+
+// NewDeviceSecret generates a random 256-bit secret (hex encoded) for a bike
+func NewDeviceSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DeviceKeyFromSecret derives the HMAC signing key from a device secret.
+// The server stores this key only sealed with SealDeviceKey; the secret
+// itself is never persisted.
+func DeviceKeyFromSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignRequest computes
+//
+//	hex(HMAC-SHA256(key, method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + body))
+//
+// where key is the hex string returned by DeviceKeyFromSecret. Binding the
+// method and path keeps a captured body from being replayed to another
+// endpoint; the nonce keeps it from being replayed to the same one.
+func SignRequest(key, method, path string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, part := range []string{method, path, strconv.FormatInt(timestamp, 10), nonce} {
+		mac.Write([]byte(part))
+		mac.Write([]byte("\n"))
+	}
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewNonce returns a random 128-bit nonce (hex encoded) for a signed request
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// InitDeviceKeyCipher sets the server-side AES-256 key (64 hex characters,
+// DEVICE_KEY_ENCRYPTION_KEY) that device HMAC keys are sealed with
+func InitDeviceKeyCipher(hexKey string) error {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return errors.New("DEVICE_KEY_ENCRYPTION_KEY must be 64 hex characters (32 bytes)")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	deviceKeyAEAD, err = cipher.NewGCM(block)
+	return err
+}
+
+// SealDeviceKey encrypts a device HMAC key with AES-GCM for storage in
+// bikes.device_key_enc. The bike_id is authenticated with it, so a sealed key
+// copied onto another bike's row does not open.
+func SealDeviceKey(bikeID, key string) ([]byte, error) {
+	if deviceKeyAEAD == nil {
+		return nil, errors.New("device key cipher not initialized")
+	}
+	nonce := make([]byte, deviceKeyAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return deviceKeyAEAD.Seal(nonce, nonce, []byte(key), []byte(bikeID)), nil
+}
+
+// OpenDeviceKey decrypts a key sealed by SealDeviceKey for the same bike
+func OpenDeviceKey(bikeID string, sealed []byte) (string, error) {
+	if deviceKeyAEAD == nil {
+		return "", errors.New("device key cipher not initialized")
+	}
+	n := deviceKeyAEAD.NonceSize()
+	if len(sealed) < n {
+		return "", errors.New("sealed device key too short")
+	}
+	key, err := deviceKeyAEAD.Open(nil, sealed[:n], sealed[n:], []byte(bikeID))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
@@ -5,6 +5,9 @@ class AppConstants {
   // API Config
   static const String apiBaseUrl =
       "https://rapteegravitee.dpdns.org/gateway/raptee-telemetry/api/v1";
+  // Backend API key (reader role), injected at build time:
+  // flutter run --dart-define=RAPTEE_API_KEY=rk_...
+  static const String apiKey = String.fromEnvironment('RAPTEE_API_KEY');
   // App Config
   static const int connectTimeout = 15000;
   static const int refreshRateMs = 5000;
@@ -19,6 +19,8 @@ class ApiClient {
           headers: {
             'Content-Type': 'application/json',
             'Accept': 'application/json',
+            if (AppConstants.apiKey.isNotEmpty)
+              'X-API-Key': AppConstants.apiKey,
           },
         ),
       ) {
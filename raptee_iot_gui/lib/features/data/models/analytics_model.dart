@@ -117,7 +117,7 @@ class APIStat {
 class ConnectivityStats {
   final Map<String, int> stateDistribution;
   final Map<String, double> failureRateByState;
-  final Map<String, List<int>> latencyByState;
+  final Map<String, LatencySummary> latencyByState;
 
   ConnectivityStats({
     required this.stateDistribution,
@@ -137,13 +137,45 @@ class ConnectivityStats {
           {},
       latencyByState:
           (json['latency_by_state'] as Map<String, dynamic>?)?.map(
-            (k, v) => MapEntry(k, (v as List).map((e) => e as int).toList()),
+            (k, v) => MapEntry(k, LatencySummary.fromJson(v)),
           ) ??
           {},
     );
   }
 }
 
+class LatencySummary {
+  final int count;
+  final double min;
+  final double p25;
+  final double p50;
+  final double p75;
+  final double max;
+  final double mean;
+
+  LatencySummary({
+    required this.count,
+    required this.min,
+    required this.p25,
+    required this.p50,
+    required this.p75,
+    required this.max,
+    required this.mean,
+  });
+
+  factory LatencySummary.fromJson(Map<String, dynamic> json) {
+    return LatencySummary(
+      count: json['count'] ?? 0,
+      min: (json['min'] ?? 0).toDouble(),
+      p25: (json['p25'] ?? 0).toDouble(),
+      p50: (json['p50'] ?? 0).toDouble(),
+      p75: (json['p75'] ?? 0).toDouble(),
+      max: (json['max'] ?? 0).toDouble(),
+      mean: (json['mean'] ?? 0).toDouble(),
+    );
+  }
+}
+
 class FailureIncident {
   final String timestamp;
   final String apiName;
@@ -600,9 +600,12 @@ class _DetailsViewState extends State<_DetailsView> {
         .toList();
 
     // Box Plot Data Preparation
-    // Syncfusion BoxAndWhiskerSeries needs a list of objects, and xValueMapper/yValueMapper (list of values)
+    // The server sends a summary per state, not every latency. In inclusive
+    // mode the quartiles of [min, p25, p50, p75, max] are exactly p25/p50/p75,
+    // so the box matches the server's percentiles.
     final List<_BoxPlotData> boxData = stats.latencyByState.entries.map((e) {
-      return _BoxPlotData(e.key, e.value.map((v) => v.toDouble()).toList());
+      final s = e.value;
+      return _BoxPlotData(e.key, [s.min, s.p25, s.p50, s.p75, s.max]);
     }).toList();
 
     return Column(
@@ -741,7 +744,8 @@ class _DetailsViewState extends State<_DetailsView> {
                       dataSource: boxData,
                       xValueMapper: (d, _) => d.x,
                       yValueMapper: (d, _) => d.y,
-                      boxPlotMode: BoxPlotMode.normal,
+                      boxPlotMode: BoxPlotMode.inclusive,
+                      showMean: false,
                       animationDuration: 500,
                     ),
                   ],